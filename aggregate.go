@@ -0,0 +1,280 @@
+package pocketexport
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+)
+
+// aggregateDefaultSeparator is the separator of the joined values
+const aggregateDefaultSeparator = ", "
+
+var (
+	errInvalidAggregate = validation.NewError(
+		"validation_invalid_aggregate",
+		"invalid aggregate {{.aggregate}} of the field {{.field}}",
+	)
+	errAggregateNotNumeric = validation.NewError(
+		"validation_aggregate_not_numeric",
+		"the aggregate {{.aggregate}} needs the numeric field {{.field}}",
+	)
+	errAggregateNotComparable = validation.NewError(
+		"validation_aggregate_not_comparable",
+		"the aggregate {{.aggregate}} needs the numeric or date field {{.field}}",
+	)
+	errAggregateJSONOnly = validation.NewError(
+		"validation_aggregate_json_only",
+		"the aggregate json is only available for the json outputs",
+	)
+)
+
+// aggregateModes are the aggregation modes of the multi-valued headers
+var aggregateModes = []string{
+	AggregateJoin,
+	AggregateCount,
+	AggregateFirst,
+	AggregateLast,
+	AggregateSum,
+	AggregateMin,
+	AggregateMax,
+	AggregateAvg,
+	AggregateJSON,
+	AggregateExists,
+}
+
+// aggregateNumericModes are the aggregation modes of the numeric fields
+var aggregateNumericModes = []string{
+	AggregateSum,
+	AggregateAvg,
+}
+
+// aggregateComparableModes are the aggregation modes of the numeric and date fields
+var aggregateComparableModes = []string{
+	AggregateMin,
+	AggregateMax,
+}
+
+// validateAggregate validates the aggregation mode of the header field, the numeric modes
+// need a number field, min and max a number or date field and the json mode a json output
+func (s *PocketExport) validateAggregate(export *Export, item *HeaderItem) error {
+	if item.Aggregate == "" {
+		return nil
+	}
+
+	if !list.ExistInSlice(item.Aggregate, aggregateModes) || item.Expression != "" {
+		return errInvalidAggregate.SetParams(map[string]any{
+			"aggregate": item.Aggregate,
+			"field":     item.FieldName,
+		})
+	}
+
+	if item.Aggregate == AggregateJSON && export.GetString(FormatField) != FormatJSON {
+		return errAggregateJSONOnly
+	}
+
+	numeric := list.ExistInSlice(item.Aggregate, aggregateNumericModes)
+	if !numeric && !list.ExistInSlice(item.Aggregate, aggregateComparableModes) {
+		return nil
+	}
+
+	collections, name, err := exportFieldCollections(s.app.Dao(), export.ExportCollection(), item.FieldName)
+	if err != nil {
+		return err
+	}
+
+	fieldType := ""
+	if name == schema.FieldNameCreated || name == schema.FieldNameUpdated {
+		fieldType = schema.FieldTypeDate
+	} else if field := collections[len(collections)-1].Schema.GetFieldByName(name); field != nil {
+		fieldType = field.Type
+	}
+
+	params := map[string]any{"aggregate": item.Aggregate, "field": item.FieldName}
+	switch {
+	case fieldType == schema.FieldTypeNumber, fieldType == schema.FieldTypeJson:
+		// the json values are checked when they are aggregated
+		return nil
+	case numeric:
+		return errAggregateNotNumeric.SetParams(params)
+	case fieldType != schema.FieldTypeDate:
+		return errAggregateNotComparable.SetParams(params)
+	}
+
+	return nil
+}
+
+// generateExportGetRecordValues returns the unformatted values of the field path, the multi relations
+// of the path are followed to every expanded record and the multi-valued fields are flattened.
+// multi is false if the path has a single value, like generateExportGetRawRecordValue returns it.
+func (s *PocketExport) generateExportGetRecordValues(r *models.Record, splitKey []string) (values []any, multi bool) {
+	records := []*models.Record{r}
+
+	// go to the nested records
+	for k, key := range splitKey[:len(splitKey)-1] {
+		// the json fields are followed by their json path
+		if len(records) > 0 && isJSONField(records[0].Collection(), key) {
+			return generateExportGetJSONPathValues(records, splitKey[k:], multi)
+		}
+
+		nested := make([]*models.Record, 0, len(records))
+		for _, record := range records {
+			switch v := record.Expand()[key].(type) {
+			case *models.Record:
+				nested = append(nested, v)
+			case []*models.Record:
+				nested = append(nested, v...)
+				multi = true
+			}
+		}
+		records = nested
+	}
+
+	key := splitKey[len(splitKey)-1]
+	if len(records) > 0 && strings.Contains(key, "[") && isJSONField(records[0].Collection(), key) {
+		return generateExportGetJSONPathValues(records, splitKey[len(splitKey)-1:], multi)
+	}
+
+	for _, record := range records {
+		var value any
+		if record.Collection().IsAuth() {
+			value = record.PublicExport()[key]
+		} else {
+			value = record.Get(key)
+		}
+
+		switch v := value.(type) {
+		case []string:
+			for _, item := range v {
+				values = append(values, item)
+			}
+			multi = true
+		case []any:
+			values = append(values, v...)
+			multi = true
+		default:
+			if !isMaskEmpty(v) {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values, multi
+}
+
+// generateExportGetJSONPathValues returns the values of the json path of the records,
+// multi is true if it is already or if the json path has a wildcard
+func generateExportGetJSONPathValues(records []*models.Record, segments []string, multi bool) ([]any, bool) {
+	values := []any{}
+	for _, record := range records {
+		v, wildcard := exportJSONPathValues(record, segments)
+		values = append(values, v...)
+		multi = multi || wildcard
+	}
+
+	return values, multi
+}
+
+// aggregateDate returns the earliest or the latest date of the values, false if there is no date
+func aggregateDate(values []any, latest bool) (types.DateTime, bool) {
+	var result types.DateTime
+	found := false
+	for _, value := range values {
+		v, ok := value.(types.DateTime)
+		if !ok || v.IsZero() {
+			continue
+		}
+
+		if !found || (latest && v.Time().After(result.Time())) || (!latest && v.Time().Before(result.Time())) {
+			result = v
+			found = true
+		}
+	}
+
+	return result, found
+}
+
+// aggregate aggregates the formatted values of a multi-valued header with its mode,
+// the numeric modes skip the non-numeric values and format their result
+func (i *HeaderItem) aggregate(values []any) any {
+	switch i.Aggregate {
+	case AggregateCount:
+		return len(values)
+	case AggregateFirst, AggregateLast:
+		if len(values) == 0 {
+			return ""
+		}
+
+		if i.Aggregate == AggregateFirst {
+			return i.Format(values[0])
+		}
+
+		return i.Format(values[len(values)-1])
+	case AggregateExists:
+		return len(values) > 0
+	case AggregateSum, AggregateMin, AggregateMax, AggregateAvg:
+		if i.Aggregate == AggregateMin || i.Aggregate == AggregateMax {
+			if date, ok := aggregateDate(values, i.Aggregate == AggregateMax); ok {
+				return i.Format(date)
+			}
+		}
+
+		numbers := make([]float64, 0, len(values))
+		for _, value := range values {
+			if v, err := cast.ToFloat64E(value); err == nil {
+				numbers = append(numbers, v)
+			}
+		}
+
+		if len(numbers) == 0 {
+			if i.Aggregate == AggregateSum {
+				return i.Format(0.0)
+			}
+
+			return ""
+		}
+
+		result := numbers[0]
+		for _, v := range numbers[1:] {
+			switch i.Aggregate {
+			case AggregateMin:
+				result = math.Min(result, v)
+			case AggregateMax:
+				result = math.Max(result, v)
+			default:
+				result += v
+			}
+		}
+
+		if i.Aggregate == AggregateAvg {
+			result /= float64(len(numbers))
+		}
+
+		return i.Format(result)
+	case AggregateJSON:
+		formatted := make([]any, len(values))
+		for j := range values {
+			formatted[j] = i.Format(values[j])
+		}
+
+		return formatted
+	}
+
+	separator := i.Separator
+	if separator == "" {
+		separator = aggregateDefaultSeparator
+	}
+
+	parts := make([]string, len(values))
+	for j := range values {
+		parts[j] = fmt.Sprintf("%v", i.Format(values[j]))
+	}
+
+	return strings.Join(parts, separator)
+}
@@ -0,0 +1,205 @@
+package pocketexport
+
+import (
+	"bytes"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// setupMultiValuedMessages adds the multi relation products and the multi select labels
+// to the messages, test1 has 2 products and 2 labels, test2 has none
+func setupMultiValuedMessages(t *testing.T, app core.App) {
+	dao := app.Dao()
+	products := &models.Collection{
+		Name:     "products",
+		Type:     models.CollectionTypeBase,
+		ListRule: types.Pointer(""),
+		ViewRule: types.Pointer(""),
+		Schema: schema.NewSchema(
+			&schema.SchemaField{Name: "name", Type: schema.FieldTypeText, Options: &schema.TextOptions{}},
+			&schema.SchemaField{Name: "price", Type: schema.FieldTypeNumber, Options: &schema.NumberOptions{}},
+		),
+	}
+	if err := dao.SaveCollection(products); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := dao.FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages.Schema.AddField(&schema.SchemaField{
+		Name:    "products",
+		Type:    schema.FieldTypeRelation,
+		Options: &schema.RelationOptions{CollectionId: products.Id},
+	})
+	messages.Schema.AddField(&schema.SchemaField{
+		Name:    "labels",
+		Type:    schema.FieldTypeSelect,
+		Options: &schema.SelectOptions{MaxSelect: 3, Values: []string{"a", "b", "c"}},
+	})
+	if err := dao.SaveCollection(messages); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, p := range []map[string]any{{"name": "bút", "price": 10}, {"name": "vở", "price": 25.5}} {
+		product := models.NewRecord(products)
+		product.Load(p)
+		if err := dao.SaveRecord(product); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, product.Id)
+	}
+
+	message, err := dao.FindFirstRecordByData("messages", "message", "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Set("products", ids)
+	message.Set("labels", []string{"a", "c"})
+	if err := dao.SaveRecord(message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_HeaderItem_aggregate(t *testing.T) {
+	two := 2
+	values := []any{10.0, "x", 25.5}
+
+	scenarios := []struct {
+		item   HeaderItem
+		values []any
+		expect any
+	}{
+		{HeaderItem{}, values, "10, x, 25.5"},
+		{HeaderItem{Aggregate: AggregateJoin, Separator: " | "}, values, "10 | x | 25.5"},
+		{HeaderItem{Aggregate: AggregateCount}, values, 3},
+		{HeaderItem{Aggregate: AggregateFirst}, values, 10.0},
+		{HeaderItem{Aggregate: AggregateLast}, values, 25.5},
+		{HeaderItem{Aggregate: AggregateLast}, nil, ""},
+		{HeaderItem{Aggregate: AggregateSum}, values, 35.5},
+		{HeaderItem{Aggregate: AggregateSum}, nil, 0.0},
+		{HeaderItem{Aggregate: AggregateMin}, values, 10.0},
+		{HeaderItem{Aggregate: AggregateMax}, values, 25.5},
+		{HeaderItem{Aggregate: AggregateAvg, Locale: "vi", Decimals: &two}, values, "17,75"},
+		{HeaderItem{Aggregate: AggregateAvg}, nil, ""},
+		{HeaderItem{Aggregate: AggregateJoin, ValueMap: map[string]any{"x": "y"}}, values, "10, y, 25.5"},
+	}
+
+	for i, s := range scenarios {
+		if v := s.item.aggregate(s.values); v != s.expect {
+			t.Fatalf("(%d) expect %v (%T), got %v (%T)", i, s.expect, s.expect, v, v)
+		}
+	}
+}
+
+func Test_pocketExport_MultiValuedHeaders(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupMultiValuedMessages(t, testApp)
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "products.name", "header": "sản phẩm"},
+		map[string]any{"fieldName": "products.name", "header": "số", "aggregate": "count"},
+		map[string]any{"fieldName": "products.name", "header": "cuối", "aggregate": "last"},
+		map[string]any{"fieldName": "products.price", "header": "tổng", "aggregate": "sum"},
+		map[string]any{"fieldName": "products.price", "header": "tb", "aggregate": "avg"},
+		map[string]any{"fieldName": "labels", "header": "nhãn", "separator": " | "},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "nội dung,sản phẩm,số,cuối,tổng,tb,nhãn\n" +
+		"test1,\"bút, vở\",2,vở,35.5,17.75,a | c\n" +
+		"test2,,0,,0,,\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// json arrays
+	record = getExportRecord(t, testApp)
+	record.Set(FormatField, FormatJSON)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "products.name", "header": "sản phẩm", "aggregate": "json"},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = `[{"sản phẩm":["bút","vở"]},{"sản phẩm":[]}]`
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the values are masked one by one
+	CollectionFieldPolicy("products", "users", FieldPolicy{
+		Masks: map[string]Mask{"name": MaskLast(1)},
+	})(&exportService.config)
+	record = getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(FilterField, `message = "test1"`)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "products.name", "header": "sản phẩm"},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = "sản phẩm\n" +
+		"\"**t, *ở\"\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	scenarios := []struct {
+		header map[string]any
+		code   string
+	}{
+		{map[string]any{"fieldName": "products.name", "aggregate": "unknown"}, "validation_invalid_aggregate"},
+		{map[string]any{"fieldName": "products.name", "aggregate": "sum"}, "validation_aggregate_not_numeric"},
+		{map[string]any{"fieldName": "products.price", "aggregate": "json"}, "validation_aggregate_json_only"},
+		{map[string]any{"expression": `products.name + ""`}, "validation_invalid_headers"},
+	}
+
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		s.header["header"] = "x"
+		record.Set(HeadersField, []any{s.header})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+}
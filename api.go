@@ -0,0 +1,189 @@
+package pocketexport
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/search"
+	"github.com/spf13/cast"
+)
+
+// previewDefaultLimit is the default number of rows returned by the preview api
+const previewDefaultLimit = 10
+
+// fieldsDefaultDepth is the default relation depth returned by the fields api
+const fieldsDefaultDepth = 1
+
+// bindApis registers the pocketexport api routes
+func (p *PocketExport) bindApis() {
+	p.app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		subGroup := e.Router.Group("/api/pocketexport", apis.ActivityLogger(p.app))
+		subGroup.GET("/stream", p.streamHandler, apis.RequireAdminOrRecordAuth())
+		subGroup.POST("/stream", p.streamHandler, apis.RequireAdminOrRecordAuth())
+		subGroup.POST("/preview", p.previewHandler, apis.RequireAdminOrRecordAuth())
+		subGroup.GET("/fields/:collection", p.fieldsHandler, apis.RequireAdminOrRecordAuth())
+		subGroup.GET("/download/:id", p.downloadHandler)
+		subGroup.POST("/download/:id/link", p.downloadLinkHandler, apis.RequireAdminOrRecordAuth())
+		subGroup.GET("/shared/:token", p.sharedHandler)
+		subGroup.GET("/usage", p.usageHandler, apis.RequireAdminOrRecordAuth())
+
+		return nil
+	})
+}
+
+// newExportFromRequest creates a validated, unsaved export from the request payload,
+// the export is always owned by the requesting admin or auth record.
+func (p *PocketExport) newExportFromRequest(c echo.Context) (*Export, error) {
+	collection, err := p.app.Dao().FindCollectionByNameOrId(PocketExportCollectionName)
+	if err != nil {
+		return nil, apis.NewNotFoundError("", err)
+	}
+
+	info := apis.RequestInfo(c)
+	data := info.Data
+	if c.Request().Method == http.MethodGet {
+		data = info.Query
+	}
+
+	record := models.NewRecord(collection)
+	form := forms.NewRecordUpsert(p.app, record)
+	form.SetFullManageAccess(true)
+	if err := form.LoadData(data); err != nil {
+		return nil, apis.NewBadRequestError("Failed to load the submitted data.", err)
+	}
+
+	if err := form.ValidateAndFill(); err != nil {
+		return nil, apis.NewBadRequestError("Failed to load the submitted data.", err)
+	}
+
+	if info.Admin != nil {
+		record.Set(OwnerIdField, info.Admin.Id)
+		record.Set(OwnerCollectionNameField, "")
+	} else if info.AuthRecord != nil {
+		record.Set(OwnerIdField, info.AuthRecord.Id)
+		record.Set(OwnerCollectionNameField, info.AuthRecord.Collection().Name)
+	}
+
+	export, err := p.ValidateAndFill(record)
+	if err != nil {
+		return nil, apis.NewBadRequestError("Failed to validate the export.", err)
+	}
+
+	return export, nil
+}
+
+// streamHandler generates the export output straight to the response
+// without storing a record or a file.
+func (p *PocketExport) streamHandler(c echo.Context) error {
+	// check the rate limits before the rows are counted
+	if err := p.checkExportRateLimits(requestOwnerKey(c)); err != nil {
+		return err
+	}
+
+	export, err := p.newExportFromRequest(c)
+	if err != nil {
+		return err
+	}
+
+	format := export.GetString(FormatField)
+	filename := fmt.Sprintf(
+		"%s_%s.%s",
+		export.ExportCollection().Name,
+		time.Now().UTC().Format("20060102150405"),
+		format,
+	)
+
+	if err := p.reserveExport(export.Record); err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, formatContentTypes[format])
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	res.WriteHeader(http.StatusOK)
+
+	// the stream is charged from its audit record
+	p.auditExport(c, AuditActionStream, export.Record, "")
+	p.releaseExport(export.Record)
+
+	return p.GenerateExportOutput(res, export)
+}
+
+// previewHandler returns the header labels, the first formatted rows
+// and the total matching count of the export.
+func (p *PocketExport) previewHandler(c echo.Context) error {
+	export, err := p.newExportFromRequest(c)
+	if err != nil {
+		return err
+	}
+
+	limit := previewDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		limit = cast.ToInt(v)
+	}
+
+	if limit <= 0 || limit > search.MaxPerPage {
+		return apis.NewBadRequestError(
+			fmt.Sprintf("The limit must be between 1 and %d.", search.MaxPerPage),
+			nil,
+		)
+	}
+
+	preview, err := p.GenerateExportPreview(export, limit)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to generate the export preview.", err)
+	}
+
+	return c.JSON(http.StatusOK, preview)
+}
+
+// fieldsHandler returns the tree of exportable fields of a collection.
+func (p *PocketExport) fieldsHandler(c echo.Context) error {
+	collection, err := p.app.Dao().FindCollectionByNameOrId(c.PathParam("collection"))
+	if err != nil || collection == nil {
+		return apis.NewNotFoundError("", err)
+	}
+
+	if !p.config.isCollectionExportable(collection.Name) {
+		return apis.NewForbiddenError("The collection cannot be exported.", nil)
+	}
+
+	info := apis.RequestInfo(c)
+
+	// admin only collections cannot be exported by auth records
+	if info.Admin == nil {
+		rule, err := p.collectionExportRule(collection)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to load the export rule.", err)
+		}
+
+		if rule == nil {
+			return apis.NewForbiddenError("Only admins can export this collection.", nil)
+		}
+	}
+
+	depth := fieldsDefaultDepth
+	if v := c.QueryParam("depth"); v != "" {
+		depth = cast.ToInt(v)
+	}
+
+	if depth < 0 || depth > exportFieldsMaxDepth {
+		return apis.NewBadRequestError(
+			fmt.Sprintf("The depth must be between 0 and %d.", exportFieldsMaxDepth),
+			nil,
+		)
+	}
+
+	fields, err := p.ExportableFields(collection, info.AuthRecord, info.Admin, depth)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to load the exportable fields.", err)
+	}
+
+	return c.JSON(http.StatusOK, fields)
+}
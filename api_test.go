@@ -0,0 +1,250 @@
+package pocketexport
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tokens"
+)
+
+func newRegisteredTestApp(opts ...RegisterOption) func() (*tests.TestApp, error) {
+	return func() (*tests.TestApp, error) {
+		testApp, err := tests.NewTestApp("./test_data")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := Register(testApp, opts...); err != nil {
+			return nil, err
+		}
+
+		return testApp, nil
+	}
+}
+
+func getAdminToken(t *testing.T) string {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	admin, err := testApp.Dao().FindAdminById("x9fs8mten7zmwcv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.NewAdminAuthToken(testApp, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func getUserToken(t *testing.T, id string) string {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	user, err := testApp.Dao().FindRecordById("users", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.NewRecordAuthToken(testApp, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func Test_pocketExport_StreamApi(t *testing.T) {
+	adminToken := getAdminToken(t)
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	body := `{
+		"exportCollectionName": "messages",
+		"headers": [
+			{"fieldName": "message", "header": "nội dung"},
+			{"fieldName": "author.name", "header": "tên tác giả"}
+		],
+		"sort": "created",
+		"format": "csv"
+	}`
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "guest",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/stream",
+			Body:            strings.NewReader(body),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:   "invalid payload",
+			Method: http.MethodPost,
+			Url:    "/api/pocketexport/stream",
+			Body: strings.NewReader(`{
+				"exportCollectionName": "messages",
+				"headers": [{"fieldName": "wrong_field", "header": "x"}],
+				"format": "csv"
+			}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"headers":`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:           "auth record csv",
+			Method:         http.MethodPost,
+			Url:            "/api/pocketexport/stream",
+			Body:           strings.NewReader(body),
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				// the other author is hidden by the users list rule
+				"nội dung,tên tác giả\ntest1,test1\ntest2,\n",
+			},
+			TestAppFactory: newRegisteredTestApp(),
+			ExpectedEvents: map[string]int{},
+		},
+		{
+			Name:   "admin json via query",
+			Method: http.MethodGet,
+			Url: "/api/pocketexport/stream?exportCollectionName=messages&sort=-created&format=json" +
+				"&headers=%5B%7B%22fieldName%22%3A%22message%22%2C%22header%22%3A%22m%22%7D%5D",
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`[{"m":"test2"},{"m":"test1"}]`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_PreviewApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	body := `{
+		"exportCollectionName": "messages",
+		"headers": [
+			{"fieldName": "message", "header": "nội dung", "valueMap": {"test1": "first"}},
+			{"fieldName": "author.name", "header": "tên tác giả"}
+		],
+		"sort": "created",
+		"format": "csv"
+	}`
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "guest",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/preview",
+			Body:            strings.NewReader(body),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:            "invalid limit",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/preview?limit=0",
+			Body:            strings.NewReader(body),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:           "auth record",
+			Method:         http.MethodPost,
+			Url:            "/api/pocketexport/preview?limit=1",
+			Body:           strings.NewReader(body),
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"headers":["nội dung","tên tác giả"]`,
+				`"rows":[["first","test1"]]`,
+				`"totalItems":2`,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_FieldsApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "guest",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/fields/messages",
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:            "missing collection",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/fields/missing",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:            "invalid depth",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/fields/messages?depth=7",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:           "without relations",
+			Method:         http.MethodGet,
+			Url:            "/api/pocketexport/fields/messages?depth=0",
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`{"fieldName":"id","name":"id","type":"text","system":true}`,
+				`{"fieldName":"message","name":"message","type":"text","system":false}`,
+				`{"fieldName":"author","name":"author","type":"relation","system":false}`,
+			},
+			NotExpectedContent: []string{`"author.name"`},
+			TestAppFactory:     newRegisteredTestApp(),
+		},
+		{
+			Name:           "with relations",
+			Method:         http.MethodGet,
+			Url:            "/api/pocketexport/fields/messages",
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`{"fieldName":"author.email","name":"email","type":"email","system":true}`,
+				`{"fieldName":"author.name","name":"name","type":"text","system":false}`,
+			},
+			NotExpectedContent: []string{`"author.tokenKey"`, `"author.passwordHash"`},
+			TestAppFactory:     newRegisteredTestApp(),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
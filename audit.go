@@ -0,0 +1,161 @@
+package pocketexport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/spf13/cast"
+)
+
+var errAuditAppendOnly = errors.New("the audit records cannot be created, updated or deleted")
+
+// fileChecksum returns the hex encoded sha256 of the file content
+func fileChecksum(file *filesystem.File) (string, error) {
+	r, err := file.Reader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// auditExport appends an audit record of the action on the export record,
+// the actor and the client are read from the request, c is nil for the background actions.
+// The audit record is saved without hooks, a failure is logged and does not fail the action.
+func (p *PocketExport) auditExport(c echo.Context, action string, record *models.Record, shareId string) {
+	collection, err := p.app.Dao().FindCollectionByNameOrId(PocketExportAuditCollectionName)
+	if err != nil {
+		log.Printf("pocketexport: audit %s of export %s failed: %v", action, record.Id, err)
+		return
+	}
+
+	audit := models.NewRecord(collection)
+	audit.Set(ActionField, action)
+	audit.Set(ExportIdField, record.Id)
+	audit.Set(ShareIdField, shareId)
+	audit.Set(ExportCollectionNameField, record.GetString(ExportCollectionNameField))
+	audit.Set(FilterField, record.GetString(FilterField))
+	audit.Set(HeadersField, record.Get(HeadersField))
+	audit.Set(RowCountField, record.GetInt(RowCountField))
+	audit.Set(ChecksumField, record.GetString(ChecksumField))
+
+	if c != nil {
+		info := apis.RequestInfo(c)
+		if info.Admin != nil {
+			audit.Set(ActorIdField, info.Admin.Id)
+		} else if info.AuthRecord != nil {
+			audit.Set(ActorIdField, info.AuthRecord.Id)
+			audit.Set(ActorCollectionNameField, info.AuthRecord.Collection().Name)
+		}
+		audit.Set(IpField, c.RealIP())
+		audit.Set(UserAgentField, c.Request().UserAgent())
+	}
+
+	if err := p.app.Dao().WithoutHooks().SaveRecord(audit); err != nil {
+		log.Printf("pocketexport: audit %s of export %s failed: %v", action, record.Id, err)
+	}
+}
+
+// bindAudit audits the export api actions and keeps the audit collection append-only,
+// the audit records are only written by the plugin and only admins can read them.
+func (p *PocketExport) bindAudit() {
+	p.app.OnModelBeforeCreate().Add(func(e *core.ModelEvent) error {
+		if e.Model.TableName() == PocketExportAuditCollectionName {
+			return errAuditAppendOnly
+		}
+
+		return nil
+	})
+
+	p.app.OnModelBeforeUpdate().Add(func(e *core.ModelEvent) error {
+		if e.Model.TableName() == PocketExportAuditCollectionName {
+			return errAuditAppendOnly
+		}
+
+		return nil
+	})
+
+	p.app.OnModelBeforeDelete().Add(func(e *core.ModelEvent) error {
+		if e.Model.TableName() == PocketExportAuditCollectionName {
+			return errAuditAppendOnly
+		}
+
+		return nil
+	})
+
+	p.app.OnRecordAfterCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		if e.Record.TableName() != PocketExportShareCollectionName {
+			return nil
+		}
+
+		record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, e.Record.GetString(ShareExportField))
+		if err != nil {
+			return nil
+		}
+
+		p.auditExport(e.HttpContext, AuditActionShare, record, e.Record.Id)
+		return nil
+	})
+
+	p.app.OnRecordAfterDeleteRequest().Add(func(e *core.RecordDeleteEvent) error {
+		if e.Record.TableName() != PocketExportCollectionName {
+			return nil
+		}
+
+		p.auditExport(e.HttpContext, AuditActionDelete, e.Record, "")
+		return nil
+	})
+
+	// the output is also downloaded from the files api with a file token
+	p.app.OnFileDownloadRequest().Add(func(e *core.FileDownloadEvent) error {
+		if e.Record.TableName() != PocketExportCollectionName || e.FileField.Name != OutputField {
+			return nil
+		}
+
+		p.loadFileTokenActor(e.HttpContext)
+		p.auditExport(e.HttpContext, AuditActionDownload, e.Record, "")
+		return nil
+	})
+}
+
+// loadFileTokenActor loads the admin or auth record of the file token in the request context,
+// the files api checks the token without loading them
+func (p *PocketExport) loadFileTokenActor(c echo.Context) {
+	if c.Get(apis.ContextAdminKey) != nil || c.Get(apis.ContextAuthRecordKey) != nil {
+		return
+	}
+
+	token := strings.TrimSpace(c.QueryParam("token"))
+	if token == "" {
+		return
+	}
+
+	claims, _ := security.ParseUnverifiedJWT(token)
+	switch cast.ToString(claims["type"]) {
+	case tokens.TypeAdmin:
+		if admin, err := p.app.Dao().FindAdminByToken(token, p.app.Settings().AdminFileToken.Secret); err == nil {
+			c.Set(apis.ContextAdminKey, admin)
+		}
+	case tokens.TypeAuthRecord:
+		if record, err := p.app.Dao().FindAuthRecordByToken(token, p.app.Settings().RecordFileToken.Secret); err == nil {
+			c.Set(apis.ContextAuthRecordKey, record)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package pocketexport
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tokens"
+)
+
+// findAuditRecords returns the audit records of the export ordered by creation
+func findAuditRecords(t *testing.T, app *tests.TestApp, exportId string) []*models.Record {
+	records, err := app.Dao().FindRecordsByExpr(
+		PocketExportAuditCollectionName,
+		dbx.HashExp{ExportIdField: exportId},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func getAdminFileToken(t *testing.T) string {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	admin, err := testApp.Dao().FindAdminById("x9fs8mten7zmwcv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.NewAdminFileToken(testApp, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func Test_pocketExport_AuditExport(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	exportService.bindAudit()
+
+	record := getExportRecord(t, testApp)
+	record.Set(RowCountField, 2)
+	record.Set(ChecksumField, "abc")
+	exportService.auditExport(nil, AuditActionGenerate, record, "")
+
+	audits := findAuditRecords(t, testApp, "test")
+	if len(audits) != 1 {
+		t.Fatalf("expect 1 audit, got %d", len(audits))
+	}
+
+	audit := audits[0]
+	if audit.GetString(ActionField) != AuditActionGenerate {
+		t.Fatal(audit.GetString(ActionField))
+	} else if audit.GetString(ExportCollectionNameField) != "messages" || audit.GetString(FilterField) != `message != ""` {
+		t.Fatal("should copy the export collection and filter")
+	} else if audit.GetInt(RowCountField) != 2 || audit.GetString(ChecksumField) != "abc" {
+		t.Fatal("should copy the row count and checksum")
+	} else if audit.GetString(ActorIdField) != "" {
+		t.Fatal("background actions have no actor")
+	}
+
+	// append-only
+	audit.Set(FilterField, "")
+	if err := testApp.Dao().SaveRecord(audit); err == nil {
+		t.Fatal("should not update the audit")
+	}
+
+	if err := testApp.Dao().DeleteRecord(audit); err == nil {
+		t.Fatal("should not delete the audit")
+	}
+}
+
+func Test_pocketExport_AuditApi(t *testing.T) {
+	adminToken := getAdminToken(t)
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	validToken := getDownloadToken(t, "test")
+	adminFileToken := getAdminFileToken(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "create",
+			Method: http.MethodPost,
+			Url:    "/api/collections/" + PocketExportCollectionName + "/records",
+			Body: strings.NewReader(`{
+				"exportCollectionName": "messages",
+				"headers": [{"fieldName": "message", "header": "nội dung"}],
+				"filter": "message != ''",
+				"format": "csv",
+				"ownerId": "vzz4enej24xtni9",
+				"ownerCollectionName": "users"
+			}`),
+			RequestHeaders: map[string]string{
+				"Authorization": userToken,
+				"User-Agent":    "audit-test",
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"success"`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+				"OnRecordAfterCreateRequest":  1,
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				records, err := app.Dao().FindRecordsByExpr(
+					PocketExportCollectionName,
+					dbx.HashExp{OwnerIdField: "vzz4enej24xtni9"},
+				)
+				if err != nil || len(records) != 1 {
+					t.Fatal("should create the export")
+				}
+
+				audits := findAuditRecords(t, app, records[0].Id)
+				if len(audits) != 2 {
+					t.Fatalf("expect 2 audits, got %d", len(audits))
+				}
+
+				for i, action := range []string{AuditActionCreate, AuditActionGenerate} {
+					audit := audits[i]
+					if audit.GetString(ActionField) != action {
+						t.Fatalf("(%d) expect %s, got %s", i, action, audit.GetString(ActionField))
+					} else if audit.GetString(ActorIdField) != "vzz4enej24xtni9" || audit.GetString(ActorCollectionNameField) != "users" {
+						t.Fatalf("(%d) wrong actor", i)
+					} else if audit.GetString(UserAgentField) != "audit-test" || audit.GetString(IpField) == "" {
+						t.Fatalf("(%d) should record the client", i)
+					} else if audit.GetString(ChecksumField) != records[0].GetString(ChecksumField) || audit.GetString(ChecksumField) == "" {
+						t.Fatalf("(%d) should record the checksum", i)
+					}
+				}
+			},
+		},
+		{
+			Name:            "download",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/download/test?token=" + validToken,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"nội dung,ngày tạo"},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 1,
+				"OnModelAfterCreate":  1,
+				"OnModelBeforeUpdate": 1,
+				"OnModelAfterUpdate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record := saveNotifyExportRecord(t, app)
+				if err := New(app).uploadExportOutput(record); err != nil {
+					t.Fatal(err)
+				}
+			},
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				audits := findAuditRecords(t, app, "test")
+				if len(audits) != 1 || audits[0].GetString(ActionField) != AuditActionDownload {
+					t.Fatal("should audit the download")
+				}
+			},
+		},
+		{
+			Name:            "files download",
+			Method:          http.MethodGet,
+			Url:             "/api/files/" + PocketExportCollectionName + "/test/output_audit.csv?token=" + adminFileToken,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"nội dung,ngày tạo"},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":   1,
+				"OnModelAfterCreate":    1,
+				"OnModelBeforeUpdate":   2,
+				"OnModelAfterUpdate":    2,
+				"OnFileDownloadRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record := saveNotifyExportRecord(t, app)
+				record.Set(StatusField, StatusSuccess)
+				record.Set(OutputField, "output_audit.csv")
+				if err := New(app).uploadExportOutput(record); err != nil {
+					t.Fatal(err)
+				}
+				if err := app.Dao().SaveRecord(record); err != nil {
+					t.Fatal(err)
+				}
+			},
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				audits := findAuditRecords(t, app, "test")
+				if len(audits) != 1 || audits[0].GetString(ActionField) != AuditActionDownload {
+					t.Fatal("should audit the download")
+				} else if audits[0].GetString(ActorIdField) != "x9fs8mten7zmwcv" {
+					t.Fatal("should record the actor")
+				}
+			},
+		},
+		{
+			Name:            "user list",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportAuditCollectionName + "/records",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+		},
+		{
+			Name:            "admin list",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportAuditCollectionName + "/records",
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"totalItems":0`},
+			ExpectedEvents: map[string]int{
+				"OnRecordsListRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+		},
+		{
+			Name:            "admin create",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportAuditCollectionName + "/records",
+			Body:            strings.NewReader(`{"action": "create", "exportId": "forged"}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+				"OnModelBeforeCreate":         1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
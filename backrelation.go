@@ -0,0 +1,307 @@
+package pocketexport
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/resolvers"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+var errInvalidBackRelation = validation.NewError(
+	"validation_invalid_back_relation",
+	"invalid back-relation field {{.field}}, expected collection_via_field or collection_via_field.name",
+)
+
+// backRelationRegex matches the back-relation of a field path, eg. comments_via_post
+var backRelationRegex = regexp.MustCompile(`^(\w+)_via_(\w+)$`)
+
+// isBackRelationPath checks whether the field path of the collection starts with a back-relation,
+// a field of the collection named like a back-relation, eg. sent_via_email, is not a back-relation
+func isBackRelationPath(collection *models.Collection, fieldName string) bool {
+	name := strings.SplitN(fieldName, ".", 2)[0]
+	if exportFieldExists(collection, name) {
+		return false
+	}
+
+	return backRelationRegex.MatchString(name)
+}
+
+// backRelationFieldPath returns the field path of the id of the back-relation records for
+// the bare back-relation paths, eg. comments_via_post.id for comments_via_post, else the path
+func backRelationFieldPath(collection *models.Collection, fieldName string) string {
+	if !strings.Contains(fieldName, ".") && isBackRelationPath(collection, fieldName) {
+		return fieldName + "." + schema.FieldNameId
+	}
+
+	return fieldName
+}
+
+// exportBackRelation returns the collection and the relation field name of the back-relation,
+// the relation field must reference the collection, eg. comments and post for comments_via_post
+func exportBackRelation(dao *daos.Dao, collection *models.Collection, name string) (*models.Collection, string, error) {
+	match := backRelationRegex.FindStringSubmatch(name)
+	if match == nil {
+		return nil, "", fmt.Errorf("%s is not a back-relation", name)
+	}
+
+	relCollection, err := dao.FindCollectionByNameOrId(match[1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	field := relCollection.Schema.GetFieldByName(match[2])
+	if field == nil || field.Type != schema.FieldTypeRelation {
+		return nil, "", fmt.Errorf("%s is not a relation of %s", match[2], relCollection.Name)
+	}
+
+	field.InitOptions()
+	if options, ok := field.Options.(*schema.RelationOptions); !ok || options.CollectionId != collection.Id {
+		return nil, "", fmt.Errorf("%s of %s does not reference %s", match[2], relCollection.Name, collection.Name)
+	}
+
+	return relCollection, match[2], nil
+}
+
+// validateBackRelationField validates the back-relation field path of the export collection,
+// the path is the back-relation or one field of the back-relation records
+func (s *PocketExport) validateBackRelationField(export *Export, fieldName string) error {
+	errInvalid := errInvalidBackRelation.SetParams(map[string]any{"field": fieldName})
+
+	splitKey := strings.Split(backRelationFieldPath(export.ExportCollection(), fieldName), ".")
+	if len(splitKey) != 2 {
+		return errInvalid
+	}
+
+	collection, _, err := exportBackRelation(s.app.Dao(), export.ExportCollection(), splitKey[0])
+	if err != nil {
+		return errInvalid
+	}
+
+	if !exportFieldExists(collection, splitKey[1]) {
+		return errInvalid
+	}
+
+	return nil
+}
+
+// exportFieldExists checks whether the collection has the field, the system fields included
+func exportFieldExists(collection *models.Collection, name string) bool {
+	if list.ExistInSlice(name, schema.BaseModelFieldNames()) {
+		return true
+	}
+
+	if collection.IsAuth() && list.ExistInSlice(name, schema.AuthFieldNames()) {
+		return true
+	}
+
+	return collection.Schema.GetFieldByName(name) != nil
+}
+
+// backRelationCount is the expand of the back-relations that are only counted,
+// the number of back-relation records of the record
+type backRelationCount int
+
+// isBackRelationCountOnly checks whether the export headers only count the back-relation records
+// or check their existence, then the records are counted without being loaded.
+// The templates can read every expanded record so they need the records.
+func isBackRelationCountOnly(export *Export, name string) bool {
+	collection := export.ExportCollection()
+	if strings.SplitN(export.GetString(UnwindField), ".", 2)[0] == name {
+		return false
+	}
+
+	headers := export.Headers()
+	counted := false
+	for i := range headers {
+		item := &headers[i]
+		if tmpl, _ := item.ParsedTemplate(); tmpl != nil {
+			return false
+		}
+
+		fieldNames, _ := item.FieldNames()
+		for _, fieldName := range fieldNames {
+			path := backRelationFieldPath(collection, fieldName)
+			if strings.SplitN(path, ".", 2)[0] != name {
+				continue
+			}
+
+			if item.Expression != "" || path != name+"."+schema.FieldNameId ||
+				(item.Aggregate != AggregateCount && item.Aggregate != AggregateExists) {
+				return false
+			}
+			counted = true
+		}
+	}
+
+	return counted
+}
+
+// backRelationQuery returns the query of the back-relation records referencing the ids
+// and the column of the referenced id, the single relations are matched with IN and
+// the multi relations through their json array. The query is filtered by the view rule
+// of the back-relation collection for the owner.
+func backRelationQuery(
+	dao *daos.Dao,
+	export *Export,
+	collection *models.Collection,
+	fieldName string,
+	name string,
+	ids []string,
+) (*dbx.SelectQuery, string, error) {
+	placeholders := make([]string, len(ids))
+	params := dbx.Params{}
+	for i, id := range ids {
+		key := fmt.Sprintf("backRelationId%d", i)
+		placeholders[i] = "{:" + key + "}"
+		params[key] = id
+	}
+
+	query := dao.RecordQuery(collection)
+	column := fmt.Sprintf("[[%s.%s]]", collection.Name, fieldName)
+
+	field := collection.Schema.GetFieldByName(fieldName)
+	field.InitOptions()
+	if options, ok := field.Options.(*schema.RelationOptions); !ok || options.MaxSelect == nil || *options.MaxSelect != 1 {
+		query.InnerJoin(
+			fmt.Sprintf("json_each(CASE WHEN json_valid(%[1]s) THEN %[1]s ELSE json_array(%[1]s) END) {{__backRelation}}", column),
+			dbx.NewExp("1 = 1"),
+		)
+		column = "[[__backRelation.value]]"
+	}
+	query.AndWhere(dbx.NewExp(fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ",")), params))
+
+	if err := applyExportViewRule(dao, export, collection, query, name); err != nil {
+		return nil, "", err
+	}
+
+	return query, column, nil
+}
+
+// applyExportViewRule filters the query of the related collection records with the view rule
+// of the collection for the owner like the expands, the admin only collections fail for the auth records
+func applyExportViewRule(dao *daos.Dao, export *Export, collection *models.Collection, query *dbx.SelectQuery, name string) error {
+	if export.Admin() != nil {
+		return nil
+	}
+
+	if collection.ViewRule == nil {
+		return errFieldNotVisible.SetParams(map[string]any{"field": name})
+	}
+
+	if *collection.ViewRule == "" {
+		return nil
+	}
+
+	resolver := resolvers.NewRecordFieldResolver(dao, collection, &models.RequestInfo{
+		Method:     http.MethodGet,
+		Query:      map[string]any{},
+		Data:       map[string]any{},
+		Headers:    map[string]any{},
+		AuthRecord: export.AuthRecord(),
+		Admin:      export.Admin(),
+	}, true)
+	expr, err := search.FilterData(*collection.ViewRule).BuildExpr(resolver)
+	if err != nil {
+		return err
+	}
+	if err := resolver.UpdateQuery(query); err != nil {
+		return err
+	}
+	query.AndWhere(expr)
+
+	return nil
+}
+
+// generateExportExpandBackRelations expands the back-relation records of the page records
+// with a query per back-relation, sorted by creation and filtered by the view rule of
+// their collection for the owner, the records without back-relation records have an empty expand.
+// The back-relations that are only counted are counted in sql and expand to their count.
+func (s *PocketExport) generateExportExpandBackRelations(records []*models.Record, export *Export, names []string) error {
+	if len(records) == 0 || len(names) == 0 {
+		return nil
+	}
+
+	dao := s.app.Dao()
+	recordsById := make(map[string]*models.Record, len(records))
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		recordsById[record.Id] = record
+		ids = append(ids, record.Id)
+	}
+
+	for _, name := range names {
+		collection, fieldName, err := exportBackRelation(dao, export.ExportCollection(), name)
+		if err != nil {
+			return err
+		}
+
+		query, column, err := backRelationQuery(dao, export, collection, fieldName, name, ids)
+		if err != nil {
+			return err
+		}
+
+		if isBackRelationCountOnly(export, name) {
+			counts := []struct {
+				Id    string `db:"id"`
+				Count int    `db:"count"`
+			}{}
+			if err := query.
+				Select(column+" AS [[id]]", fmt.Sprintf("COUNT(DISTINCT [[%s.%s]]) AS [[count]]", collection.Name, schema.FieldNameId)).
+				GroupBy(column).
+				All(&counts); err != nil {
+				return err
+			}
+
+			expands := make(map[string]backRelationCount, len(records))
+			for _, c := range counts {
+				expands[c.Id] = backRelationCount(c.Count)
+			}
+
+			for id, record := range recordsById {
+				expand := record.Expand()
+				expand[name] = expands[id]
+				record.SetExpand(expand)
+			}
+			continue
+		}
+
+		related := []*models.Record{}
+		if err := query.
+			Distinct(true).
+			OrderBy(fmt.Sprintf("[[%s.%s]] ASC", collection.Name, schema.FieldNameCreated)).
+			All(&related); err != nil {
+			return err
+		}
+
+		expands := make(map[string][]*models.Record, len(records))
+		for _, record := range records {
+			expands[record.Id] = []*models.Record{}
+		}
+
+		for _, r := range related {
+			for _, id := range r.GetStringSlice(fieldName) {
+				if _, ok := recordsById[id]; ok {
+					expands[id] = append(expands[id], r)
+				}
+			}
+		}
+
+		for id, rs := range expands {
+			record := recordsById[id]
+			expand := record.Expand()
+			expand[name] = rs
+			record.SetExpand(expand)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package pocketexport
+
+import (
+	"bytes"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// setupBackRelations adds the comments of the messages, viewable by their author, the admin only
+// notes of the messages and the topics with multiple messages
+func setupBackRelations(t *testing.T, app core.App) {
+	dao := app.Dao()
+	messages, err := dao.FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCollection := func(name string, viewRule *string, fields ...*schema.SchemaField) *models.Collection {
+		collection := &models.Collection{
+			Name:     name,
+			Type:     models.CollectionTypeBase,
+			ListRule: viewRule,
+			ViewRule: viewRule,
+			Schema:   schema.NewSchema(fields...),
+		}
+		if err := dao.SaveCollection(collection); err != nil {
+			t.Fatal(err)
+		}
+
+		return collection
+	}
+
+	messageRelation := func(name string, maxSelect *int) *schema.SchemaField {
+		return &schema.SchemaField{
+			Name:    name,
+			Type:    schema.FieldTypeRelation,
+			Options: &schema.RelationOptions{CollectionId: messages.Id, MaxSelect: maxSelect},
+		}
+	}
+
+	comments := newCollection("comments", types.Pointer("author = @request.auth.id"),
+		messageRelation("message", types.Pointer(1)),
+		&schema.SchemaField{Name: "body", Type: schema.FieldTypeText, Options: &schema.TextOptions{}},
+		&schema.SchemaField{Name: "score", Type: schema.FieldTypeNumber, Options: &schema.NumberOptions{}},
+		&schema.SchemaField{
+			Name:    "author",
+			Type:    schema.FieldTypeRelation,
+			Options: &schema.RelationOptions{CollectionId: "_pb_users_auth_", MaxSelect: types.Pointer(1)},
+		},
+	)
+	newCollection("notes", nil, messageRelation("message", types.Pointer(1)))
+	topics := newCollection("topics", types.Pointer(""), messageRelation("messages", nil))
+
+	test1, err := dao.FindFirstRecordByData("messages", "message", "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test2, err := dao.FindFirstRecordByData("messages", "message", "test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []map[string]any{
+		{"message": test1.Id, "body": "a", "score": 2, "author": "vzz4enej24xtni9", "created": "2023-06-12 10:00:00.000Z"},
+		{"message": test1.Id, "body": "b", "score": 5, "author": "djh54wc2hpkhfkw", "created": "2024-06-12 10:00:00.000Z"},
+	} {
+		comment := models.NewRecord(comments)
+		comment.Load(data)
+		if err := dao.SaveRecord(comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ids := range [][]string{{test1.Id, test2.Id}, {test1.Id}} {
+		topic := models.NewRecord(topics)
+		topic.Set("messages", ids)
+		if err := dao.SaveRecord(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_pocketExport_BackRelationHeaders(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupBackRelations(t, testApp)
+
+	exportService := New(testApp)
+	headers := []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "comments_via_message", "header": "số", "aggregate": "count"},
+		map[string]any{"fieldName": "comments_via_message.score", "header": "tổng", "aggregate": "sum"},
+		map[string]any{"fieldName": "comments_via_message.score", "header": "cao nhất", "aggregate": "max"},
+		map[string]any{"fieldName": "comments_via_message", "header": "có", "aggregate": "exists"},
+		map[string]any{
+			"fieldName":  "comments_via_message.created",
+			"header":     "mới nhất",
+			"aggregate":  "max",
+			"dateLayout": "%Y",
+		},
+		map[string]any{"fieldName": "comments_via_message.body", "header": "bình luận"},
+		map[string]any{"fieldName": "topics_via_messages", "header": "chủ đề", "aggregate": "count"},
+	}
+
+	generate := func(record *models.Record) string {
+		record.Set(HeadersField, headers)
+		export, err := exportService.ValidateAndFill(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := exportService.GenerateExportOutput(buf, export); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.String()
+	}
+
+	expect := "nội dung,số,tổng,cao nhất,có,mới nhất,bình luận,chủ đề\n" +
+		"test1,2,7,5,true,2024,\"a, b\",2\n" +
+		"test2,0,0,,false,,,1\n"
+	if output := generate(getExportRecord(t, testApp)); output != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, output)
+	}
+
+	// the view rule of the comments applies to the owner
+	record := getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	expect = "nội dung,số,tổng,cao nhất,có,mới nhất,bình luận,chủ đề\n" +
+		"test1,1,2,2,true,2023,a,2\n" +
+		"test2,0,0,,false,,,1\n"
+	if output := generate(record); output != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, output)
+	}
+
+	scenarios := []struct {
+		header map[string]any
+		code   string
+	}{
+		{map[string]any{"fieldName": "notes_via_message", "aggregate": "count"}, "validation_field_not_visible"},
+		{map[string]any{"fieldName": "comments_via_unknown"}, "validation_invalid_back_relation"},
+		{map[string]any{"fieldName": "comments_via_message.unknown"}, "validation_invalid_back_relation"},
+		{map[string]any{"fieldName": "comments_via_message.author.name"}, "validation_invalid_back_relation"},
+		{map[string]any{"fieldName": "comments_via_message.body", "aggregate": "sum"}, "validation_aggregate_not_numeric"},
+		{map[string]any{"fieldName": "comments_via_message.body", "aggregate": "max"}, "validation_aggregate_not_comparable"},
+		{map[string]any{"expression": `comments_via_message.score * 2`}, "validation_invalid_headers"},
+	}
+
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		s.header["header"] = "x"
+		record.Set(HeadersField, []any{s.header})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+}
+
+func Test_pocketExport_BackRelationCountOnly(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupBackRelations(t, testApp)
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "comments_via_message", "header": "số", "aggregate": "count"},
+		map[string]any{"fieldName": "comments_via_message.id", "header": "có", "aggregate": "exists"},
+		map[string]any{"fieldName": "topics_via_messages", "header": "chủ đề", "aggregate": "count"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the single and the multi relations are counted without loading the records
+	messages, err := testApp.Dao().FindRecordsByExpr("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportService.generateExportExpandBackRelations(messages, export, []string{"comments_via_message", "topics_via_messages"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		for _, name := range []string{"comments_via_message", "topics_via_messages"} {
+			if _, ok := message.Expand()[name].(backRelationCount); !ok {
+				t.Fatalf("%s of %s should be counted, got %v", name, message.Id, message.Expand()[name])
+			}
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "nội dung,số,có,chủ đề\n" +
+		"test1,2,true,2\n" +
+		"test2,0,false,1\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the templates read the back-relation records
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "comments_via_message", "header": "số", "aggregate": "count", "template": "{{.Value}}"},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	} else if isBackRelationCountOnly(export, "comments_via_message") {
+		t.Fatal("should load the back-relation records")
+	}
+}
+
+func Test_pocketExport_BackRelationLikeField(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupBackRelations(t, testApp)
+
+	// a field named like a back-relation
+	messages, err := testApp.Dao().FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages.Schema.AddField(&schema.SchemaField{Name: "comments_via_message", Type: schema.FieldTypeText, Options: &schema.TextOptions{}})
+	if err := testApp.Dao().SaveCollection(messages); err != nil {
+		t.Fatal(err)
+	}
+
+	test1, err := testApp.Dao().FindFirstRecordByData("messages", "message", "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test1.Set("comments_via_message", "email")
+	if err := testApp.Dao().SaveRecord(test1); err != nil {
+		t.Fatal(err)
+	}
+
+	if isBackRelationPath(messages, "comments_via_message") {
+		t.Fatal("should be the field of the collection")
+	}
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "comments_via_message", "header": "gửi qua"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "nội dung,gửi qua\n" +
+		"test1,email\n" +
+		"test2,\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+}
@@ -0,0 +1,145 @@
+package pocketexport
+
+import (
+	"log"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/cron"
+)
+
+var errReadOnlyField = validation.NewError("validation_read_only", "only the pinned field can be updated")
+
+// exportRetention returns how long the export record is kept, 0 keeps it forever,
+// the export collection override takes precedence over the owner auth collection one
+func (rc *registerConfig) exportRetention(r *models.Record) time.Duration {
+	if d, ok := rc.collectionRetention[r.GetString(ExportCollectionNameField)]; ok {
+		return d
+	}
+
+	if ownerCollectionName := r.GetString(OwnerCollectionNameField); ownerCollectionName != "" {
+		if d, ok := rc.authCollectionRetention[ownerCollectionName]; ok {
+			return d
+		}
+	}
+
+	if !rc.autoDelete {
+		return 0
+	}
+
+	return rc.autoDeleteDuration
+}
+
+// ownerUsage is the number and the output size of the kept exports of an owner
+type ownerUsage struct {
+	count int
+	size  int64
+}
+
+// cleanupExports deletes the exports that are older than their retention or exceed
+// the number and storage limits of their owner, the newest exports are kept first.
+// The pinned and pending exports are ignored.
+func (p *PocketExport) cleanupExports(now time.Time) error {
+	// skip overlapping ticks while the previous cleanup is still running
+	if !p.cleanupMu.TryLock() {
+		return nil
+	}
+	defer p.cleanupMu.Unlock()
+
+	dao := p.app.Dao()
+	collection, err := dao.FindCollectionByNameOrId(PocketExportCollectionName)
+	if err != nil {
+		return err
+	}
+
+	records := []*models.Record{}
+	if err := dao.RecordQuery(collection).
+		OrderBy(OwnerCollectionNameField+" ASC", OwnerIdField+" ASC", "created DESC").
+		All(&records); err != nil {
+		return err
+	}
+
+	usages := map[string]*ownerUsage{}
+	for _, r := range records {
+		if r.GetBool(PinnedField) || r.GetString(StatusField) == StatusPending {
+			continue
+		}
+
+		owner := r.GetString(OwnerCollectionNameField) + "/" + r.GetString(OwnerIdField)
+		usage, ok := usages[owner]
+		if !ok {
+			usage = &ownerUsage{}
+			usages[owner] = usage
+		}
+
+		size := int64(r.GetInt(OutputSizeField))
+		retention := p.config.exportRetention(r)
+		expired := retention > 0 && r.Created.Time().Before(now.Add(-retention))
+		tooMany := p.config.maxExportsPerOwner > 0 && usage.count+1 > p.config.maxExportsPerOwner
+		tooLarge := p.config.ownerStorageQuota > 0 && usage.size+size > p.config.ownerStorageQuota
+
+		if !expired && !tooMany && !tooLarge {
+			usage.count++
+			usage.size += size
+			continue
+		}
+
+		if err := dao.DeleteRecord(r); err != nil {
+			log.Printf("pocketexport: delete export %s failed: %v", r.Id, err)
+			continue
+		}
+		p.auditExport(nil, AuditActionDelete, r, "")
+	}
+
+	return nil
+}
+
+// bindCleanup restricts the export updates to the pinned field and
+// starts the cleanup job when the app starts serving.
+func (p *PocketExport) bindCleanup() error {
+	p.app.OnRecordBeforeUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		if e.Record.TableName() != PocketExportCollectionName {
+			return nil
+		}
+
+		if len(e.UploadedFiles) > 0 {
+			return validation.Errors{OutputField: errReadOnlyField}
+		}
+
+		original := e.Record.OriginalCopy()
+		for _, field := range e.Record.Collection().Schema.Fields() {
+			if field.Name == PinnedField {
+				continue
+			}
+
+			if e.Record.GetString(field.Name) != original.GetString(field.Name) {
+				return validation.Errors{field.Name: errReadOnlyField}
+			}
+		}
+
+		return nil
+	})
+
+	scheduler := cron.New()
+	if err := scheduler.Add(PocketExportCollectionName, p.config.cleanupCron, func() {
+		if err := p.cleanupExports(time.Now()); err != nil {
+			log.Printf("pocketexport: cleanup exports failed: %v", err)
+		}
+	}); err != nil {
+		return err
+	}
+
+	p.app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		scheduler.Start()
+		return nil
+	})
+
+	p.app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		scheduler.Stop()
+		return nil
+	})
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package pocketexport
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func saveCleanupExportRecord(t *testing.T, app *tests.TestApp, ownerId string, created time.Time, size int) *models.Record {
+	record := getExportRecord(t, app)
+	record.Id = ""
+	record.RefreshId()
+	record.Set(OwnerIdField, ownerId)
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(StatusField, StatusSuccess)
+	record.Set(OutputSizeField, size)
+	record.Created, _ = types.ParseDateTime(created)
+	if err := app.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
+
+func Test_pocketExport_CleanupExports(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	MaxExportsPerOwner(2)(&exportService.config)
+	OwnerStorageQuota(100)(&exportService.config)
+	CollectionRetention("posts", 0)(&exportService.config)
+
+	now := time.Now()
+	expired := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-2*time.Hour), 1)
+	pinned := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-2*time.Hour), 1)
+	pinned.Set(PinnedField, true)
+	pending := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-2*time.Hour), 0)
+	pending.Set(StatusField, StatusPending)
+	for _, r := range []*models.Record{pinned, pending} {
+		if err := testApp.Dao().SaveRecord(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// max exports per owner
+	oldest := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-3*time.Minute), 1)
+	older := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-2*time.Minute), 1)
+	newest := saveCleanupExportRecord(t, testApp, "vzz4enej24xtni9", now.Add(-time.Minute), 1)
+
+	// storage quota
+	large := saveCleanupExportRecord(t, testApp, "djh54wc2hpkhfkw", now.Add(-2*time.Minute), 60)
+	largest := saveCleanupExportRecord(t, testApp, "djh54wc2hpkhfkw", now.Add(-time.Minute), 60)
+
+	if err := exportService.cleanupExports(now); err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		record *models.Record
+		kept   bool
+	}{
+		{expired, false},
+		{pinned, true},
+		{pending, true},
+		{oldest, false},
+		{older, true},
+		{newest, true},
+		{large, false},
+		{largest, true},
+	}
+
+	for i, s := range scenarios {
+		_, err := testApp.Dao().FindRecordById(PocketExportCollectionName, s.record.Id)
+		if s.kept && err != nil {
+			t.Fatalf("(%d) should keep the export", i)
+		} else if !s.kept && err == nil {
+			t.Fatalf("(%d) should delete the export", i)
+		}
+	}
+
+	// the test data exports of the admin are older than the retention
+	records, err := testApp.Dao().FindRecordsByExpr(PocketExportCollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if r.GetString(OwnerCollectionNameField) == "" {
+			t.Fatal("should delete the expired admin exports")
+		}
+	}
+}
+
+func Test_registerConfig_ExportRetention(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	rc := defaultRegisterConfig
+	AuthCollectionRetention("users", 24*time.Hour)(&rc)
+	CollectionRetention("messages", 0)(&rc)
+
+	record := getExportRecord(t, testApp)
+	if d := rc.exportRetention(record); d != 0 {
+		t.Fatalf("expect collection retention, got %v", d)
+	}
+
+	record.Set(ExportCollectionNameField, "posts")
+	if d := rc.exportRetention(record); d != time.Hour {
+		t.Fatalf("expect default retention, got %v", d)
+	}
+
+	record.Set(OwnerCollectionNameField, "users")
+	if d := rc.exportRetention(record); d != 24*time.Hour {
+		t.Fatalf("expect auth collection retention, got %v", d)
+	}
+
+	AutoDelete(false)(&rc)
+	record.Set(OwnerCollectionNameField, "")
+	if d := rc.exportRetention(record); d != 0 {
+		t.Fatalf("expect no retention, got %v", d)
+	}
+}
+
+func Test_pocketExport_PinApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	otherToken := getUserToken(t, "djh54wc2hpkhfkw")
+	beforeTestFunc := func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		record := getExportRecord(t, app)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		if err := app.Dao().SaveRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	savedEvents := map[string]int{
+		"OnModelBeforeCreate": 1,
+		"OnModelAfterCreate":  1,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "not owner",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			Body:            strings.NewReader(`{"pinned": true}`),
+			RequestHeaders:  map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(),
+			BeforeTestFunc:  beforeTestFunc,
+		},
+		{
+			Name:            "read only field",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			Body:            strings.NewReader(`{"pinned": true, "filter": "id != ''"}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"filter":{"code":"validation_read_only"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+				"OnRecordBeforeUpdateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "pin",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			Body:            strings.NewReader(`{"pinned": true}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"pinned":true`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+				"OnRecordBeforeUpdateRequest": 1,
+				"OnRecordAfterUpdateRequest":  1,
+				"OnModelBeforeUpdate":         1,
+				"OnModelAfterUpdate":          1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+			BeforeTestFunc: beforeTestFunc,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,64 @@
+package pocketexport
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tools/list"
+)
+
+var (
+	errCollectionNotExportable = validation.NewError("validation_collection_not_exportable", "the collection cannot be exported")
+	errFormatNotAllowed        = validation.NewError("validation_format_not_allowed", "the format is not allowed for the collection")
+)
+
+// CollectionConfig is the export configuration of a collection
+type CollectionConfig struct {
+	// DefaultHeaders are the headers of the exports without headers
+	DefaultHeaders []HeaderItem
+	// DefaultSort is the sort of the exports without sort
+	DefaultSort string
+	// MaxRows overrides the maximum number of rows like CollectionMaxRows, 0 keeps the default
+	MaxRows int
+	// Formats lists the allowed formats, empty allows every format
+	Formats []string
+	// RequiredFilter is a filter always applied to the exports, like the list rule
+	RequiredFilter string
+}
+
+// isCollectionExportable checks the collection against the allowed and denied collections
+func (rc *registerConfig) isCollectionExportable(collectionName string) bool {
+	if list.ExistInSlice(collectionName, rc.deniedCollections) {
+		return false
+	}
+
+	return len(rc.allowedCollections) == 0 || list.ExistInSlice(collectionName, rc.allowedCollections)
+}
+
+// validateCollectionConfig validates the export against the configuration
+// of its collection and fills the default headers and sort
+func (rc *registerConfig) validateCollectionConfig(export *Export) error {
+	collectionName := export.ExportCollection().Name
+	if !rc.isCollectionExportable(collectionName) {
+		return validation.Errors{ExportCollectionNameField: errCollectionNotExportable}
+	}
+
+	config := rc.collectionConfigs[collectionName]
+	if len(export.headers) == 0 && len(config.DefaultHeaders) > 0 {
+		export.headers = append([]HeaderItem{}, config.DefaultHeaders...)
+		export.Set(HeadersField, export.headers)
+		export.applyHeaderDefaults()
+	}
+
+	if len(export.headers) == 0 {
+		return validation.Errors{HeadersField: validation.ErrRequired}
+	}
+
+	if export.GetString(SortField) == "" && config.DefaultSort != "" {
+		export.Set(SortField, config.DefaultSort)
+	}
+
+	if len(config.Formats) > 0 && !list.ExistInSlice(export.GetString(FormatField), config.Formats) {
+		return validation.Errors{FormatField: errFormatNotAllowed}
+	}
+
+	return nil
+}
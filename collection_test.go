@@ -0,0 +1,122 @@
+package pocketexport
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func Test_registerConfig_IsCollectionExportable(t *testing.T) {
+	rc := defaultRegisterConfig
+	if !rc.isCollectionExportable("messages") {
+		t.Fatal("should export every collection by default")
+	}
+
+	AllowedCollections("messages", "users")(&rc)
+	DeniedCollections("users")(&rc)
+
+	scenarios := []struct {
+		collectionName string
+		expect         bool
+	}{
+		{"messages", true},
+		{"users", false},
+		{"posts", false},
+	}
+
+	for _, s := range scenarios {
+		if rc.isCollectionExportable(s.collectionName) != s.expect {
+			t.Fatalf("%s: expect %v", s.collectionName, s.expect)
+		}
+	}
+}
+
+func Test_pocketExport_CollectionExportConfig(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	DeniedCollections("messages")(&exportService.config)
+	record := getExportRecord(t, testApp)
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[ExportCollectionNameField].(validation.Error).Code() != "validation_collection_not_exportable" {
+		t.Fatal(err)
+	}
+
+	exportService = New(testApp)
+	CollectionExportConfig("messages", CollectionConfig{
+		DefaultHeaders: []HeaderItem{{FieldName: "message", Header: "nội dung"}},
+		DefaultSort:    "-created",
+		MaxRows:        5,
+		Formats:        []string{FormatJSON},
+		RequiredFilter: `author = "vzz4enej24xtni9"`,
+	})(&exportService.config)
+	if exportService.config.collectionMaxRows["messages"] != 5 {
+		t.Fatal("should set the collection max rows")
+	}
+
+	// not allowed format
+	record = getExportRecord(t, testApp)
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[FormatField].(validation.Error).Code() != "validation_format_not_allowed" {
+		t.Fatal(err)
+	}
+
+	// defaults and required filter
+	record = getExportRecord(t, testApp)
+	record.Set(FormatField, FormatJSON)
+	record.Set(HeadersField, nil)
+	record.Set(SortField, "")
+	record.Set(FilterField, "")
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(export.Headers()) != 1 || export.Headers()[0].FieldName != "message" {
+		t.Fatalf("should use the default headers, got %v", export.Headers())
+	} else if record.GetString(SortField) != "-created" {
+		t.Fatal("should use the default sort")
+	} else if record.GetInt(RowCountField) != 1 {
+		t.Fatalf("expect row count 1, got %d", record.GetInt(RowCountField))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != `[{"nội dung":"test1"}]` {
+		t.Fatal(buf.String())
+	}
+
+	// no headers without default headers
+	exportService = New(testApp)
+	record = getExportRecord(t, testApp)
+	record.Set(HeadersField, nil)
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[HeadersField].(validation.Error).Code() != validation.ErrRequired.Code() {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_DeniedCollectionFieldsApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+
+	scenario := tests.ApiScenario{
+		Name:            "denied collection",
+		Method:          http.MethodGet,
+		Url:             "/api/pocketexport/fields/messages",
+		RequestHeaders:  map[string]string{"Authorization": userToken},
+		ExpectedStatus:  http.StatusForbidden,
+		ExpectedContent: []string{`"data":{}`},
+		TestAppFactory:  newRegisteredTestApp(DeniedCollections("messages")),
+	}
+
+	scenario.Test(t)
+}
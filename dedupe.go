@@ -0,0 +1,240 @@
+package pocketexport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// inflightExport is an export output being generated in the request of an export,
+// the concurrent requests of the same fingerprint wait for its content
+type inflightExport struct {
+	done    chan struct{}
+	content []byte
+	err     error
+}
+
+// exportFingerprint returns the hex encoded sha256 of the export fields that change its output
+func exportFingerprint(export *Export) (string, error) {
+	data, err := json.Marshal([]any{
+		export.GetString(OwnerIdField),
+		export.GetString(OwnerCollectionNameField),
+		export.ExportCollection().Name,
+		export.GetString(FilterField),
+		export.GetString(SortField),
+		export.Headers(),
+		export.GetString(FormatField),
+		export.GetString(DestinationField),
+		export.GetString(DestinationPathField),
+		export.GetString(UnwindField),
+		export.GetBool(UnwindKeepEmptyField),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// findDuplicateExport returns the latest succeeded or pending export with the same fingerprint
+// created within the dedupe window, nil if there is none
+func (p *PocketExport) findDuplicateExport(record *models.Record) (*models.Record, error) {
+	if p.config.dedupeWindow <= 0 {
+		return nil, nil
+	}
+
+	since, err := types.ParseDateTime(time.Now().Add(-p.config.dedupeWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*models.Record{}
+	if err := p.app.Dao().RecordQuery(record.Collection()).
+		AndWhere(dbx.HashExp{
+			FingerprintField: record.GetString(FingerprintField),
+			StatusField:      []any{StatusSuccess, StatusPending},
+			DuplicateOfField: "",
+		}).
+		AndWhere(dbx.NewExp("[[created]] >= {:since}", dbx.Params{"since": since.String()})).
+		OrderBy("created DESC").
+		Limit(1).
+		All(&records); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return records[0], nil
+}
+
+// attachDuplicateExport attaches the created export to the original export,
+// the output of a succeeded original is reused and a pending original completes both exports
+func (p *PocketExport) attachDuplicateExport(e *core.RecordCreateEvent, original *models.Record) error {
+	e.Record.Set(DuplicateOfField, original.Id)
+	if original.GetString(StatusField) == StatusPending {
+		e.Record.Set(StatusField, StatusPending)
+		return nil
+	}
+
+	file, err := p.readExportOutput(original, e.Record.GetString(OutputField))
+	if err != nil {
+		return err
+	}
+
+	copyExportOutputFields(e.Record, original)
+	if file == nil {
+		// the original stored no output, eg. only delivered to its destination
+		e.Record.Set(OutputField, "")
+		return nil
+	}
+	e.UploadedFiles[OutputField] = []*filesystem.File{file}
+
+	return nil
+}
+
+// copyExportOutputFields copies the generation status and output fields of the original export
+func copyExportOutputFields(record *models.Record, original *models.Record) {
+	for _, field := range []string{
+		StatusField,
+		ErrorField,
+		RowCountField,
+		ChecksumField,
+		OutputSizeField,
+		DestinationKeyField,
+	} {
+		record.Set(field, original.Get(field))
+	}
+}
+
+// readExportOutput reads the stored output of the export as a file named name,
+// nil if the output is not stored
+func (p *PocketExport) readExportOutput(record *models.Record, name string) (*filesystem.File, error) {
+	if record.GetInt(OutputSizeField) == 0 {
+		return nil, nil
+	}
+
+	fs, err := p.app.NewFilesystem()
+	if err != nil {
+		return nil, fmt.Errorf("get filesystem failed: %w", err)
+	}
+	defer fs.Close()
+
+	r, err := fs.GetFile(record.BaseFilesPath() + "/" + record.GetString(OutputField))
+	if err != nil {
+		return nil, fmt.Errorf("get output failed: %w", err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read output failed: %w", err)
+	}
+
+	return newExportOutputFile(content, name)
+}
+
+// generateExportFileOnce generates the export output file, the concurrent calls for exports
+// of the same fingerprint within the dedupe window share the output of the first call
+func (p *PocketExport) generateExportFileOnce(export *Export) (*filesystem.File, error) {
+	fingerprint := export.GetString(FingerprintField)
+	if p.config.dedupeWindow <= 0 || fingerprint == "" {
+		return p.generateExportFile(export)
+	}
+
+	p.inflightMu.Lock()
+	job, ok := p.inflight[fingerprint]
+	if !ok {
+		job = &inflightExport{done: make(chan struct{})}
+		p.inflight[fingerprint] = job
+	}
+	p.inflightMu.Unlock()
+
+	if ok {
+		<-job.done
+	} else {
+		func() {
+			// the waiting exports are always released, even if the generation panics
+			defer func() {
+				p.inflightMu.Lock()
+				delete(p.inflight, fingerprint)
+				p.inflightMu.Unlock()
+				close(job.done)
+			}()
+			defer recoverExportPanic(&job.err)
+
+			buf := bytes.NewBuffer(nil)
+			job.err = p.GenerateExportOutput(buf, export)
+			job.content = buf.Bytes()
+		}()
+	}
+
+	if job.err != nil {
+		return nil, job.err
+	}
+
+	return newExportOutputFile(job.content, export.GetString(OutputField))
+}
+
+// completeDuplicateExports completes the pending exports attached to the processed export
+// with its output and status, notifies their owners and their webhooks
+func (p *PocketExport) completeDuplicateExports(original *models.Record) {
+	records, err := p.app.Dao().FindRecordsByExpr(PocketExportCollectionName, dbx.HashExp{
+		DuplicateOfField: original.Id,
+		StatusField:      StatusPending,
+	})
+	if err != nil {
+		log.Printf("pocketexport: find duplicates of export %s failed: %v", original.Id, err)
+		return
+	}
+
+	for _, record := range records {
+		if err := p.completeDuplicateExport(record, original); err != nil {
+			log.Printf("pocketexport: complete duplicate export %s failed: %v", record.Id, err)
+			continue
+		}
+
+		if err := p.notifyExportEmail(record); err != nil {
+			log.Printf("pocketexport: notify email failed: %v", err)
+		}
+		p.fireExportWebhooks(record)
+	}
+}
+
+// completeDuplicateExport copies the output and the status of the original export to the record
+func (p *PocketExport) completeDuplicateExport(record *models.Record, original *models.Record) error {
+	file, err := p.readExportOutput(original, record.GetString(OutputField))
+	if err != nil {
+		return err
+	}
+
+	if file != nil {
+		fs, err := p.app.NewFilesystem()
+		if err != nil {
+			return fmt.Errorf("get filesystem failed: %w", err)
+		}
+		defer fs.Close()
+
+		if err := fs.UploadFile(file, record.BaseFilesPath()+"/"+file.Name); err != nil {
+			return fmt.Errorf("upload file failed: %w", err)
+		}
+	} else {
+		record.Set(OutputField, "")
+	}
+
+	copyExportOutputFields(record, original)
+	return p.app.Dao().SaveRecord(record)
+}
@@ -0,0 +1,246 @@
+package pocketexport
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+func Test_exportFingerprint(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	fingerprint := func(r *models.Record) string {
+		if _, err := exportService.ValidateAndFill(r); err != nil {
+			t.Fatal(err)
+		}
+
+		return r.GetString(FingerprintField)
+	}
+
+	record := getExportRecord(t, testApp)
+	expect := fingerprint(record)
+	if expect == "" {
+		t.Fatal("should set the fingerprint")
+	}
+
+	record = getExportRecord(t, testApp)
+	record.Set(NotifyEmailField, true)
+	if fingerprint(record) != expect {
+		t.Fatal("should ignore the fields not changing the output")
+	}
+
+	record = getExportRecord(t, testApp)
+	record.Set(FilterField, `message = "test1"`)
+	if fingerprint(record) == expect {
+		t.Fatal("should change with the filter")
+	}
+}
+
+func Test_pocketExport_DedupeExport(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	newRecord := func(id string) *models.Record {
+		record := getExportRecord(t, testApp)
+		record.Id = id
+		record.Set(OutputField, exportOutputFilename(FormatCSV))
+		if _, err := exportService.ValidateAndFill(record); err != nil {
+			t.Fatal(err)
+		}
+
+		return record
+	}
+
+	original := newRecord("original")
+	original.Set(StatusField, StatusPending)
+	if err := testApp.Dao().SaveRecord(original); err != nil {
+		t.Fatal(err)
+	}
+
+	// disabled by default
+	duplicate := newRecord("duplicate")
+	if found, err := exportService.findDuplicateExport(duplicate); err != nil || found != nil {
+		t.Fatalf("should not dedupe, got %v %v", found, err)
+	}
+
+	// attached to the pending export
+	DedupeWindow(time.Minute)(&exportService.config)
+	found, err := exportService.findDuplicateExport(duplicate)
+	if err != nil || found == nil || found.Id != original.Id {
+		t.Fatalf("should find the pending export, got %v %v", found, err)
+	}
+
+	e := &core.RecordCreateEvent{Record: duplicate, UploadedFiles: map[string][]*filesystem.File{}}
+	if err := exportService.attachDuplicateExport(e, found); err != nil {
+		t.Fatal(err)
+	} else if duplicate.GetString(StatusField) != StatusPending || duplicate.GetString(DuplicateOfField) != original.Id {
+		t.Fatal("should wait for the pending export")
+	}
+	if err := testApp.Dao().SaveRecord(duplicate); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.processExport(original.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if duplicate, err = testApp.Dao().FindRecordById(PocketExportCollectionName, duplicate.Id); err != nil {
+		t.Fatal(err)
+	} else if duplicate.GetString(StatusField) != StatusSuccess || duplicate.GetString(ChecksumField) == "" {
+		t.Fatal("should complete the duplicate export")
+	}
+
+	fs, err := testApp.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if exists, err := fs.Exists(duplicate.BaseFilesPath() + "/" + duplicate.GetString(OutputField)); err != nil || !exists {
+		t.Fatal("should copy the output")
+	}
+
+	// reuses the output of the succeeded export, not of its duplicates
+	another := newRecord("another")
+	if found, err = exportService.findDuplicateExport(another); err != nil || found == nil || found.Id != original.Id {
+		t.Fatalf("should find the succeeded export, got %v %v", found, err)
+	}
+
+	e = &core.RecordCreateEvent{Record: another, UploadedFiles: map[string][]*filesystem.File{}}
+	if err := exportService.attachDuplicateExport(e, found); err != nil {
+		t.Fatal(err)
+	} else if another.GetString(StatusField) != StatusSuccess || another.GetString(ChecksumField) != duplicate.GetString(ChecksumField) {
+		t.Fatal("should reuse the succeeded export")
+	}
+
+	files := e.UploadedFiles[OutputField]
+	if len(files) != 1 || files[0].Name != another.GetString(OutputField) {
+		t.Fatal("should upload the output")
+	}
+
+	r, err := files[0].Reader.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if content, err := io.ReadAll(r); err != nil || len(content) != found.GetInt(OutputSizeField) {
+		t.Fatal("should copy the output content")
+	}
+
+	// other exports
+	another = newRecord("another")
+	another.Set(FilterField, `message = "test1"`)
+	if _, err := exportService.ValidateAndFill(another); err != nil {
+		t.Fatal(err)
+	}
+	if found, err = exportService.findDuplicateExport(another); err != nil || found != nil {
+		t.Fatalf("should not dedupe, got %v %v", found, err)
+	}
+}
+
+func Test_pocketExport_DedupeDestinationExport(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	DedupeWindow(time.Minute)(&exportService.config)
+	Destination("warehouse", NewLocalDestination(t.TempDir()))(&exportService.config)
+	KeepDestinationOutput(false)(&exportService.config)
+
+	newRecord := func(id string) *models.Record {
+		record := getExportRecord(t, testApp)
+		record.Id = id
+		record.Set(OutputField, exportOutputFilename(FormatCSV))
+		record.Set(DestinationField, "warehouse")
+		record.Set(DestinationPathField, "exports/{id}.csv")
+		if _, err := exportService.ValidateAndFill(record); err != nil {
+			t.Fatal(err)
+		}
+
+		return record
+	}
+
+	original := newRecord("original")
+	original.Set(StatusField, StatusPending)
+	if err := testApp.Dao().SaveRecord(original); err != nil {
+		t.Fatal(err)
+	}
+
+	// completed by the original without stored output
+	pending := newRecord("pending")
+	e := &core.RecordCreateEvent{Record: pending, UploadedFiles: map[string][]*filesystem.File{}}
+	if err := exportService.attachDuplicateExport(e, original); err != nil {
+		t.Fatal(err)
+	}
+	if err := testApp.Dao().SaveRecord(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.processExport(original.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, pending.Id)
+	if pending.GetString(StatusField) != StatusSuccess || pending.GetString(OutputField) != "" {
+		t.Fatal("should complete the duplicate without output")
+	}
+
+	// attached to the succeeded original without stored output
+	original, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, original.Id)
+	duplicate := newRecord("duplicate")
+	e = &core.RecordCreateEvent{Record: duplicate, UploadedFiles: map[string][]*filesystem.File{}}
+	if err := exportService.attachDuplicateExport(e, original); err != nil {
+		t.Fatal(err)
+	} else if duplicate.GetString(OutputField) != "" || len(e.UploadedFiles[OutputField]) != 0 {
+		t.Fatal("should not keep an output")
+	} else if duplicate.GetString(DestinationKeyField) != original.GetString(DestinationKeyField) {
+		t.Fatal("should copy the destination key")
+	}
+
+	if _, err := exportService.newWebhookPayload(duplicate); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_generateExportFileOnce(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	DedupeWindow(time.Minute)(&exportService.config)
+
+	// the export is not filled, the generation panics
+	record := getExportRecord(t, testApp)
+	record.Set(FingerprintField, "panic")
+	for i := 0; i < 2; i++ {
+		if _, err := exportService.generateExportFileOnce(NewExport(record)); err == nil || !strings.Contains(err.Error(), "panicked") {
+			t.Fatalf("(%d) expect panic error, got %v", i, err)
+		}
+	}
+
+	if len(exportService.inflight) != 0 {
+		t.Fatal("should release the inflight export")
+	}
+}
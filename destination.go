@@ -0,0 +1,226 @@
+package pocketexport
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/sftp"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultDestinationPath is the path template of the exports without destination path
+const defaultDestinationPath = "{collection}/{id}.{format}"
+
+var (
+	errUnknownDestination       = validation.NewError("validation_unknown_destination", "unknown destination")
+	errInvalidDestinationPath   = validation.NewError("validation_invalid_destination_path", "invalid destination path")
+	errDestinationRequired      = validation.NewError("validation_destination_required", "destination is required")
+	errDestinationPathNotUnique = validation.NewError(
+		"validation_destination_path_not_unique",
+		"the destination path must contain {id}",
+	)
+)
+
+// ExportDestination is an external sink of the export outputs
+type ExportDestination interface {
+	// Upload writes the content to the key of the destination
+	Upload(content []byte, key string) error
+}
+
+// filesystemDestination uploads the outputs to a pocketbase filesystem
+type filesystemDestination struct {
+	newFilesystem func() (*filesystem.System, error)
+}
+
+// Upload implement ExportDestination interface
+func (d *filesystemDestination) Upload(content []byte, key string) error {
+	fs, err := d.newFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	return fs.Upload(content, key)
+}
+
+// NewS3Destination creates a destination uploading the outputs to a S3-compatible bucket
+func NewS3Destination(
+	bucketName string,
+	region string,
+	endpoint string,
+	accessKey string,
+	secretKey string,
+	s3ForcePathStyle bool,
+) ExportDestination {
+	return &filesystemDestination{newFilesystem: func() (*filesystem.System, error) {
+		return filesystem.NewS3(bucketName, region, endpoint, accessKey, secretKey, s3ForcePathStyle)
+	}}
+}
+
+// NewLocalDestination creates a destination writing the outputs to a local directory
+func NewLocalDestination(dirPath string) ExportDestination {
+	return &filesystemDestination{newFilesystem: func() (*filesystem.System, error) {
+		return filesystem.NewLocal(dirPath)
+	}}
+}
+
+// sftpDestination uploads the outputs to a directory of a SFTP server
+type sftpDestination struct {
+	addr   string
+	config *ssh.ClientConfig
+	dir    string
+}
+
+// NewSFTPDestination creates a destination uploading the outputs to the dir of the SFTP server at addr
+func NewSFTPDestination(addr string, config *ssh.ClientConfig, dir string) ExportDestination {
+	return &sftpDestination{addr: addr, config: config, dir: dir}
+}
+
+// Upload implement ExportDestination interface,
+// the content is written to a temporary file renamed once complete
+// so that the inbox never exposes partial files
+func (d *sftpDestination) Upload(content []byte, key string) error {
+	conn, err := ssh.Dial("tcp", d.addr, d.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	filePath := path.Join(d.dir, key)
+	if err := client.MkdirAll(path.Dir(filePath)); err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".part"
+	f, err := client.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return client.PosixRename(tmpPath, filePath)
+}
+
+// exportDestinationKey resolves the destination path template of the export record,
+// the supported placeholders are {id}, {collection}, {format}, {owner}, {date} and {time}
+func exportDestinationKey(record *models.Record, now time.Time) (string, error) {
+	template := record.GetString(DestinationPathField)
+	if template == "" {
+		template = defaultDestinationPath
+	}
+
+	now = now.UTC()
+	key := strings.NewReplacer(
+		"{id}", record.Id,
+		"{collection}", record.GetString(ExportCollectionNameField),
+		"{format}", record.GetString(FormatField),
+		"{owner}", record.GetString(OwnerIdField),
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("150405"),
+	).Replace(template)
+
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if key == "" || strings.HasSuffix(template, "/") {
+		return "", errInvalidDestinationPath
+	}
+
+	for _, segment := range strings.Split(template, "/") {
+		if segment == ".." {
+			return "", errInvalidDestinationPath
+		}
+	}
+
+	return key, nil
+}
+
+// validateDestination validates the destination and the destination path of the export record,
+// the paths of the auth records contain {id} so that they never overwrite the other exports
+func (rc *registerConfig) validateDestination(r *models.Record) error {
+	name := r.GetString(DestinationField)
+	if name == "" {
+		if r.GetString(DestinationPathField) != "" {
+			return validation.Errors{DestinationField: errDestinationRequired}
+		}
+
+		return nil
+	}
+
+	if _, ok := rc.destinations[name]; !ok {
+		return validation.Errors{DestinationField: errUnknownDestination}
+	}
+
+	if _, err := exportDestinationKey(r, time.Now()); err != nil {
+		return validation.Errors{DestinationPathField: err}
+	}
+
+	if path := r.GetString(DestinationPathField); path != "" && r.GetString(OwnerCollectionNameField) != "" &&
+		!strings.Contains(path, "{id}") {
+		return validation.Errors{DestinationPathField: errDestinationPathNotUnique}
+	}
+
+	return nil
+}
+
+// uploadExportDestination uploads the output of the export record to its destination
+// and reports whether the output is also stored in the output field
+func (p *PocketExport) uploadExportDestination(record *models.Record, file *filesystem.File) (bool, error) {
+	record.Set(DestinationKeyField, "")
+
+	name := record.GetString(DestinationField)
+	if name == "" {
+		return true, nil
+	}
+
+	destination, ok := p.config.destinations[name]
+	if !ok {
+		return false, fmt.Errorf("unknown destination %q", name)
+	}
+
+	key, err := exportDestinationKey(record, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	r, err := file.Reader.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+
+	if err := destination.Upload(content, key); err != nil {
+		return false, fmt.Errorf("upload to destination %q failed: %w", name, err)
+	}
+	record.Set(DestinationKeyField, key)
+
+	if !p.config.keepDestinationOutput {
+		record.Set(OutputField, "")
+		return false, nil
+	}
+
+	return true, nil
+}
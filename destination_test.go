@@ -0,0 +1,234 @@
+package pocketexport
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func Test_exportDestinationKey(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	now := time.Date(2026, 10, 18, 7, 5, 9, 0, time.UTC)
+	scenarios := []struct {
+		template string
+		expect   string
+		isValid  bool
+	}{
+		{"", "messages/test.csv", true},
+		{"exports/{collection}/{date}.csv", "exports/messages/2026-10-18.csv", true},
+		{"/{owner}/{date}_{time}.{format}", "x9fs8mten7zmwcv/2026-10-18_070509.csv", true},
+		{"exports//{id}.csv", "exports/test.csv", true},
+		{"../{id}.csv", "", false},
+		{"exports/", "", false},
+	}
+
+	for _, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(DestinationPathField, s.template)
+
+		key, err := exportDestinationKey(record, now)
+		if !s.isValid {
+			if err == nil {
+				t.Fatalf("%s: should have error", s.template)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", s.template, err)
+		} else if key != s.expect {
+			t.Fatalf("%s: expect %s, got %s", s.template, s.expect, key)
+		}
+	}
+}
+
+func Test_registerConfig_ValidateDestination(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	rc := defaultRegisterConfig
+	Destination("warehouse", NewLocalDestination(t.TempDir()))(&rc)
+
+	newRecord := func(ownerId string, path string) *models.Record {
+		record := getExportRecord(t, testApp)
+		record.Id = "export_" + ownerId
+		record.Set(OwnerIdField, ownerId)
+		record.Set(OwnerCollectionNameField, "users")
+		record.Set(DestinationField, "warehouse")
+		record.Set(DestinationPathField, path)
+
+		return record
+	}
+
+	// two owners cannot target the same key
+	keys := map[string]bool{}
+	for _, ownerId := range []string{"vzz4enej24xtni9", "djh54wc2hpkhfkw"} {
+		err := rc.validateDestination(newRecord(ownerId, "shared/report.csv"))
+		if e, ok := err.(validation.Errors)[DestinationPathField].(validation.Error); !ok || e.Code() != "validation_destination_path_not_unique" {
+			t.Fatalf("%s: expect not unique path, got %v", ownerId, err)
+		}
+
+		record := newRecord(ownerId, "shared/{id}.csv")
+		if err := rc.validateDestination(record); err != nil {
+			t.Fatalf("%s: %v", ownerId, err)
+		}
+
+		key, err := exportDestinationKey(record, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[key] = true
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expect 2 keys, got %v", keys)
+	}
+
+	// the admins choose the key
+	record := getExportRecord(t, testApp)
+	record.Set(DestinationField, "warehouse")
+	record.Set(DestinationPathField, "shared/report.csv")
+	if err := rc.validateDestination(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_ProcessExportDestination(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	dir := t.TempDir()
+	exportService := New(testApp)
+	Destination("warehouse", NewLocalDestination(dir))(&exportService.config)
+	KeepDestinationOutput(false)(&exportService.config)
+
+	record := getExportRecord(t, testApp)
+	record.Set(OutputField, exportOutputFilename(FormatCSV))
+	record.Set(DestinationField, "warehouse")
+	record.Set(DestinationPathField, "exports/{collection}/{id}.csv")
+	if _, err := exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := testApp.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.processExport(record.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	record, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, record.Id)
+	if record.GetString(StatusField) != StatusSuccess {
+		t.Fatal(record.GetString(ErrorField))
+	} else if record.GetString(DestinationKeyField) != "exports/messages/test.csv" {
+		t.Fatal(record.GetString(DestinationKeyField))
+	} else if record.GetString(OutputField) != "" {
+		t.Fatal("should not keep the output")
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "exports", "messages", "test.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(content), "nội dung,ngày tạo") {
+		t.Fatalf("wrong content %s", content)
+	}
+}
+
+func Test_pocketExport_DestinationApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	body := func(destination string) *strings.Reader {
+		return strings.NewReader(`{
+			"exportCollectionName": "messages",
+			"headers": [{"fieldName": "message", "header": "nội dung"}],
+			"format": "csv",
+			"ownerId": "vzz4enej24xtni9",
+			"ownerCollectionName": "users",
+			"destination": "` + destination + `",
+			"destinationPath": "exports/{collection}/{id}.csv"
+		}`)
+	}
+
+	var dir string
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unknown destination",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records",
+			Body:            body("unknown"),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"destination":{"code":"validation_unknown_destination"`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+		},
+		{
+			Name:           "delivered to destination",
+			Method:         http.MethodPost,
+			Url:            "/api/collections/" + PocketExportCollectionName + "/records",
+			Body:           body("warehouse"),
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"destinationKey":"exports/messages/`,
+				`"status":"success"`,
+			},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+				"OnRecordAfterCreateRequest":  1,
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+			},
+			TestAppFactory: func() (*tests.TestApp, error) {
+				dir = t.TempDir()
+				return newRegisteredTestApp(
+					AutoDelete(false),
+					Destination("warehouse", NewLocalDestination(dir)),
+				)()
+			},
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record, err := app.Dao().FindFirstRecordByData(PocketExportCollectionName, DestinationField, "warehouse")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// the key has the id of the created export
+				expect := "exports/messages/" + record.Id + ".csv"
+				if key := record.GetString(DestinationKeyField); key != expect {
+					t.Fatalf("expect destination key %s, got %s", expect, key)
+				}
+
+				files, err := filepath.Glob(filepath.Join(dir, "exports", "messages", "*.csv"))
+				if err != nil || len(files) != 1 || files[0] != filepath.Join(dir, expect) {
+					t.Fatal("should deliver the output")
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,272 @@
+package pocketexport
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+)
+
+// downloadTokenType is the type claim of the download tokens
+const downloadTokenType = "pocketexport_download"
+
+var errInvalidDownloadToken = errors.New("invalid or expired download token")
+
+// downloadTokenSecret returns the secret used to sign the download tokens
+func (p *PocketExport) downloadTokenSecret() string {
+	if p.config.downloadTokenSecret != "" {
+		return p.config.downloadTokenSecret
+	}
+
+	return p.app.Settings().RecordFileToken.Secret
+}
+
+// newDownloadToken creates a token allowing to download the export output until it expires,
+// a token with a nonce can only be used once
+func (p *PocketExport) newDownloadToken(record *models.Record, duration time.Duration, nonce string) (string, error) {
+	claims := jwt.MapClaims{
+		"id":   record.Id,
+		"type": downloadTokenType,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return security.NewToken(claims, p.downloadTokenSecret(), int64(duration.Seconds()))
+}
+
+// verifyDownloadToken verifies the token and returns its claims
+func (p *PocketExport) verifyDownloadToken(token string, recordId string) (jwt.MapClaims, error) {
+	claims, err := security.ParseJWT(token, p.downloadTokenSecret())
+	if err != nil {
+		return nil, errInvalidDownloadToken
+	}
+
+	if claims["type"] != downloadTokenType || claims["id"] != recordId {
+		return nil, errInvalidDownloadToken
+	}
+
+	return claims, nil
+}
+
+// downloadURL returns the absolute download url of the export output
+func (p *PocketExport) downloadURL(record *models.Record, token string) string {
+	return strings.TrimRight(p.app.Settings().Meta.AppUrl, "/") +
+		"/api/pocketexport/download/" + url.PathEscape(record.Id) +
+		"?token=" + url.QueryEscape(token)
+}
+
+// DownloadLink is a signed link to the export output
+type DownloadLink struct {
+	Url     string         `json:"url"`
+	Expires types.DateTime `json:"expires"`
+	OneTime bool           `json:"oneTime"`
+}
+
+// isExportOwner checks whether the admin or auth record owns the export record
+func isExportOwner(record *models.Record, admin *models.Admin, authRecord *models.Record) bool {
+	if admin != nil {
+		return record.GetString(OwnerIdField) == admin.Id &&
+			record.GetString(OwnerCollectionNameField) == ""
+	}
+
+	if authRecord != nil {
+		return record.GetString(OwnerIdField) == authRecord.Id &&
+			record.GetString(OwnerCollectionNameField) == authRecord.Collection().Name
+	}
+
+	return false
+}
+
+// exportDownloadNonces returns the unused one-time link nonces
+// of the export record mapped to their unix expiration
+func exportDownloadNonces(record *models.Record) map[string]int64 {
+	nonces := map[string]int64{}
+	record.UnmarshalJSONField(DownloadNoncesField, &nonces)
+
+	now := time.Now().Unix()
+	for nonce, expires := range nonces {
+		if expires < now {
+			delete(nonces, nonce)
+		}
+	}
+
+	return nonces
+}
+
+// newDownloadLink creates a signed link to the output of the export record,
+// a one-time link nonce is saved on the record until the link is used or expires
+func (p *PocketExport) newDownloadLink(record *models.Record, oneTime bool) (*DownloadLink, error) {
+	duration := p.config.downloadLinkDuration
+	expires, err := types.ParseDateTime(time.Now().Add(duration))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := ""
+	if oneTime {
+		p.downloadMu.Lock()
+		defer p.downloadMu.Unlock()
+
+		record, err = p.app.Dao().FindRecordById(PocketExportCollectionName, record.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce = security.RandomString(20)
+		nonces := exportDownloadNonces(record)
+		nonces[nonce] = expires.Time().Unix()
+		record.Set(DownloadNoncesField, nonces)
+		if err := p.app.Dao().SaveRecord(record); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := p.newDownloadToken(record, duration, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DownloadLink{
+		Url:     p.downloadURL(record, token),
+		Expires: expires,
+		OneTime: oneTime,
+	}, nil
+}
+
+// downloadLinkHandler issues a signed download link to the export owner
+// and to the auth records the export is shared with.
+func (p *PocketExport) downloadLinkHandler(c echo.Context) error {
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, c.PathParam("id"))
+	if err != nil {
+		return apis.NewNotFoundError("", err)
+	}
+
+	info := apis.RequestInfo(c)
+	if !isExportOwner(record, info.Admin, info.AuthRecord) {
+		shared, err := p.isExportSharedWith(record, info.AuthRecord)
+		if err != nil || !shared {
+			return apis.NewNotFoundError("", err)
+		}
+	}
+
+	if record.GetString(OutputField) == "" || record.GetString(StatusField) == StatusFailed {
+		return apis.NewNotFoundError("The export has no output.", nil)
+	}
+
+	if record.GetString(StatusField) == StatusPending {
+		return apis.NewBadRequestError("The export output is not generated yet.", nil)
+	}
+
+	link, err := p.newDownloadLink(record, cast.ToBool(info.Data["oneTime"]))
+	if err != nil {
+		return apis.NewBadRequestError("Failed to create the download link.", err)
+	}
+
+	return c.JSON(http.StatusOK, link)
+}
+
+// recordExportDownload consumes the nonce of a one-time link and
+// updates the download counter of the export record.
+func (p *PocketExport) recordExportDownload(recordId string, nonce string) (*models.Record, error) {
+	p.downloadMu.Lock()
+	defer p.downloadMu.Unlock()
+
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, recordId)
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce != "" {
+		nonces := exportDownloadNonces(record)
+		if _, ok := nonces[nonce]; !ok {
+			return nil, errInvalidDownloadToken
+		}
+
+		delete(nonces, nonce)
+		record.Set(DownloadNoncesField, nonces)
+	}
+
+	record.Set(DownloadCountField, record.GetInt(DownloadCountField)+1)
+	record.Set(LastDownloadedAtField, time.Now().UTC())
+	if err := p.app.Dao().SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// bindDownloads counts the output downloads from the files api like the signed link downloads,
+// the files api serves the output to the export owner and to the share recipients with a file token
+func (p *PocketExport) bindDownloads() {
+	p.app.OnFileDownloadRequest().Add(func(e *core.FileDownloadEvent) error {
+		if e.Record.TableName() != PocketExportCollectionName || e.FileField.Name != OutputField {
+			return nil
+		}
+
+		record, err := p.recordExportDownload(e.Record.Id, "")
+		if err != nil {
+			return apis.NewBadRequestError("Failed to record the download.", err)
+		}
+		e.Record = record
+
+		return nil
+	})
+}
+
+// downloadHandler serves the export output to the holders of a valid download token.
+func (p *PocketExport) downloadHandler(c echo.Context) error {
+	recordId := c.PathParam("id")
+	claims, err := p.verifyDownloadToken(c.QueryParam("token"), recordId)
+	if err != nil {
+		return apis.NewForbiddenError("The download link is invalid or has expired.", nil)
+	}
+
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, recordId)
+	if err != nil {
+		return apis.NewNotFoundError("", err)
+	}
+
+	if record.GetString(OutputField) == "" || record.GetString(StatusField) == StatusFailed {
+		return apis.NewNotFoundError("", nil)
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	if record, err = p.recordExportDownload(recordId, nonce); err != nil {
+		if errors.Is(err, errInvalidDownloadToken) {
+			return apis.NewForbiddenError("The download link has already been used.", nil)
+		}
+
+		return apis.NewBadRequestError("Failed to record the download.", err)
+	}
+	p.auditExport(c, AuditActionDownload, record, "")
+
+	return p.serveExportOutput(c, record)
+}
+
+// serveExportOutput serves the output file of the export record without caching
+func (p *PocketExport) serveExportOutput(c echo.Context, record *models.Record) error {
+	fs, err := p.app.NewFilesystem()
+	if err != nil {
+		return apis.NewBadRequestError("Filesystem initialization failure.", err)
+	}
+	defer fs.Close()
+
+	filename := record.GetString(OutputField)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if err := fs.Serve(c.Response(), c.Request(), record.BaseFilesPath()+"/"+filename, filename); err != nil {
+		return apis.NewNotFoundError("", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package pocketexport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func getDownloadToken(t *testing.T, id string) string {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	record := &models.Record{}
+	record.Id = id
+	token, err := New(testApp).newDownloadToken(record, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url.QueryEscape(token)
+}
+
+func Test_pocketExport_DownloadApi(t *testing.T) {
+	validToken := getDownloadToken(t, "test")
+	otherToken := getDownloadToken(t, "other")
+	adminFileToken := getAdminFileToken(t)
+	beforeTestFunc := func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		record := saveNotifyExportRecord(t, app)
+		if err := New(app).uploadExportOutput(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the export is saved before the request
+	downloadEvents := map[string]int{
+		"OnModelBeforeCreate": 1,
+		"OnModelAfterCreate":  1,
+		"OnModelBeforeUpdate": 1,
+		"OnModelAfterUpdate":  1,
+	}
+	errorEvents := map[string]int{
+		"OnModelBeforeCreate": 1,
+		"OnModelAfterCreate":  1,
+		"OnBeforeApiError":    1,
+		"OnAfterApiError":     1,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "invalid token",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/download/test?token=invalid",
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc,
+		},
+		{
+			Name:            "token of another export",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/download/test?token=" + otherToken,
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc,
+		},
+		{
+			Name:            "missing export",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/download/test?token=" + validToken,
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents: map[string]int{
+				"OnBeforeApiError": 1,
+				"OnAfterApiError":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+		},
+		{
+			Name:            "valid token",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/download/test?token=" + validToken,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"nội dung,ngày tạo"},
+			ExpectedEvents:  downloadEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc,
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record, err := app.Dao().FindRecordById(PocketExportCollectionName, "test")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if record.GetInt(DownloadCountField) != 1 {
+					t.Fatalf("expect 1 download, got %d", record.GetInt(DownloadCountField))
+				} else if record.GetDateTime(LastDownloadedAtField).IsZero() {
+					t.Fatal("should have last download")
+				}
+			},
+		},
+		{
+			Name:            "files api",
+			Method:          http.MethodGet,
+			Url:             "/api/files/" + PocketExportCollectionName + "/test/output_download.csv?token=" + adminFileToken,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"nội dung,ngày tạo"},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":   1,
+				"OnModelAfterCreate":    1,
+				"OnModelBeforeUpdate":   2,
+				"OnModelAfterUpdate":    2,
+				"OnFileDownloadRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record := saveNotifyExportRecord(t, app)
+				record.Set(StatusField, StatusSuccess)
+				record.Set(OutputField, "output_download.csv")
+				if err := New(app).uploadExportOutput(record); err != nil {
+					t.Fatal(err)
+				}
+				if err := app.Dao().SaveRecord(record); err != nil {
+					t.Fatal(err)
+				}
+			},
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record, err := app.Dao().FindRecordById(PocketExportCollectionName, "test")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if record.GetInt(DownloadCountField) != 1 {
+					t.Fatalf("expect 1 download, got %d", record.GetInt(DownloadCountField))
+				} else if record.GetDateTime(LastDownloadedAtField).IsZero() {
+					t.Fatal("should have last download")
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_DownloadLinkApi(t *testing.T) {
+	adminToken := getAdminToken(t)
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	beforeTestFunc := func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		record := getExportRecord(t, app)
+		record.Set(StatusField, StatusSuccess)
+		record.Set(OutputField, exportOutputFilename(FormatCSV))
+		if err := app.Dao().SaveRecord(record); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := New(app).uploadExportOutput(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// download requests the link and returns the response status
+	download := func(t *testing.T, e *echo.Echo, link string) int {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+		return rec.Code
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "guest",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/download/test/link",
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 1,
+				"OnModelAfterCreate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "not owner",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/download/test/link",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 1,
+				"OnModelAfterCreate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:           "one-time link",
+			Method:         http.MethodPost,
+			Url:            "/api/pocketexport/download/test/link",
+			Body:           strings.NewReader(`{"oneTime": true}`),
+			RequestHeaders: map[string]string{"Authorization": adminToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"url":"http://localhost:8090/api/pocketexport/download/test?token=`,
+				`"oneTime":true`,
+			},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 1,
+				"OnModelAfterCreate":  1,
+				"OnModelBeforeUpdate": 1,
+				"OnModelAfterUpdate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record, err := app.Dao().FindRecordById(PocketExportCollectionName, "test")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				link, err := New(app).newDownloadLink(record, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if code := download(t, e, link.Url); code != http.StatusOK {
+					t.Fatalf("expect 200, got %d", code)
+				}
+
+				if code := download(t, e, link.Url); code != http.StatusForbidden {
+					t.Fatalf("expect 403, got %d", code)
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
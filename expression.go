@@ -0,0 +1,785 @@
+package pocketexport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+)
+
+// expressionMaxLength is the maximum length of a header expression
+const expressionMaxLength = 1000
+
+var errInvalidExpression = validation.NewError("validation_invalid_expression", "invalid expression {{.expression}}: {{.error}}")
+
+// exprNode is a node of a parsed expression, eval returns nil when the node
+// cannot be evaluated for the row, eg. a division by zero
+type exprNode interface {
+	eval(get func(splitKey []string) any) any
+}
+
+// Expression is a parsed header expression
+type Expression struct {
+	root   exprNode
+	fields []string
+}
+
+// Fields returns the field paths used by the expression
+func (e *Expression) Fields() []string {
+	return e.fields
+}
+
+// Eval evaluates the expression with the field values returned by get
+func (e *Expression) Eval(get func(splitKey []string) any) any {
+	return e.root.eval(get)
+}
+
+// ParseExpression parses a header expression, eg. `quantity * price`,
+// `concat(author.name, " <", author.email, ">")` or `if(paid, "Yes", "No")`.
+// The expressions have no loop and can only read the fields of the exported record
+// and of its related records.
+func ParseExpression(src string) (*Expression, error) {
+	if len(src) > expressionMaxLength {
+		return nil, fmt.Errorf("the expression is longer than %d characters", expressionMaxLength)
+	}
+
+	tokens, err := tokenizeExpression(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens, fields: map[string]bool{}}
+	root, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != exprTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+
+	fields := make([]string, 0, len(p.fields))
+	for _, tok := range tokens {
+		if tok.kind == exprTokenIdent && p.fields[tok.text] {
+			fields = append(fields, tok.text)
+			delete(p.fields, tok.text)
+		}
+	}
+
+	return &Expression{root: root, fields: fields}, nil
+}
+
+type exprTokenKind int
+
+const (
+	exprTokenEOF exprTokenKind = iota
+	exprTokenNumber
+	exprTokenString
+	exprTokenIdent
+	exprTokenOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+// exprOperators are the operators and punctuation of the expressions, the longest first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ","}
+
+// tokenizeExpression splits the expression in tokens
+func tokenizeExpression(src string) ([]exprToken, error) {
+	tokens := []exprToken{}
+
+	for pos := 0; pos < len(src); {
+		r, size := utf8.DecodeRuneInString(src[pos:])
+
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case r >= '0' && r <= '9':
+			end := pos
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenNumber, text: src[pos:end], pos: pos})
+			pos = end
+		case r == '"' || r == '\'':
+			end := pos + 1
+			var text strings.Builder
+			for end < len(src) && rune(src[end]) != r {
+				if src[end] == '\\' && end+1 < len(src) {
+					end++
+				}
+				text.WriteByte(src[end])
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenString, text: text.String(), pos: pos})
+			pos = end + 1
+		case r == '_' || unicode.IsLetter(r):
+			end := pos
+			for end < len(src) {
+				r, size := utf8.DecodeRuneInString(src[end:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenIdent, text: src[pos:end], pos: pos})
+			pos = end
+		default:
+			op := ""
+			for _, o := range exprOperators {
+				if strings.HasPrefix(src[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", r, pos)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenOp, text: op, pos: pos})
+			pos += len(op)
+		}
+	}
+
+	return append(tokens, exprToken{kind: exprTokenEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// exprBinaryPrecedence is the precedence of the binary operators
+var exprBinaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// exprUnaryPrecedence is the precedence of the unary operators
+const exprUnaryPrecedence = 7
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	fields map[string]bool
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != exprTokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *exprParser) expect(op string) error {
+	if tok := p.next(); tok.kind != exprTokenOp || tok.text != op {
+		return fmt.Errorf("expected %q at %d, got %q", op, tok.pos, tok.text)
+	}
+
+	return nil
+}
+
+// parse parses the binary operations of a precedence greater than minPrecedence
+func (p *exprParser) parse(minPrecedence int) (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		precedence, ok := exprBinaryPrecedence[tok.text]
+		if tok.kind != exprTokenOp || !ok || precedence <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parse(precedence)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &exprBinary{op: tok.text, x: left, y: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if tok := p.peek(); tok.kind == exprTokenOp && (tok.text == "!" || tok.text == "-") {
+		p.next()
+
+		x, err := p.parse(exprUnaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprUnary{op: tok.text, x: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case exprTokenNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return &exprLiteral{value: v}, nil
+	case exprTokenString:
+		return &exprLiteral{value: tok.text}, nil
+	case exprTokenIdent:
+		switch tok.text {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null":
+			return &exprLiteral{value: nil}, nil
+		}
+
+		if next := p.peek(); next.kind == exprTokenOp && next.text == "(" {
+			return p.parseCall(tok)
+		}
+
+		p.fields[tok.text] = true
+		return &exprField{splitKey: strings.Split(tok.text, ".")}, nil
+	case exprTokenOp:
+		if tok.text == "(" {
+			x, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+
+			return x, p.expect(")")
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	fn, ok := exprFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	p.next()
+
+	args := []exprNode{}
+	if tok := p.peek(); tok.kind == exprTokenOp && tok.text == ")" {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			tok := p.next()
+			if tok.kind == exprTokenOp && tok.text == ")" {
+				break
+			}
+
+			if tok.kind != exprTokenOp || tok.text != "," {
+				return nil, fmt.Errorf("expected \",\" or \")\" at %d, got %q", tok.pos, tok.text)
+			}
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments of %s at %d", name.text, name.pos)
+	}
+
+	return &exprCall{fn: fn, args: args}, nil
+}
+
+type exprLiteral struct {
+	value any
+}
+
+func (n *exprLiteral) eval(get func(splitKey []string) any) any {
+	return n.value
+}
+
+type exprField struct {
+	splitKey []string
+}
+
+func (n *exprField) eval(get func(splitKey []string) any) any {
+	return exprValue(get(n.splitKey))
+}
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+func (n *exprUnary) eval(get func(splitKey []string) any) any {
+	x := n.x.eval(get)
+	if n.op == "!" {
+		return !exprTruthy(x)
+	}
+
+	if v, ok := x.(float64); ok {
+		return -v
+	}
+
+	return nil
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+func (n *exprBinary) eval(get func(splitKey []string) any) any {
+	x := n.x.eval(get)
+
+	switch n.op {
+	case "&&":
+		return exprTruthy(x) && exprTruthy(n.y.eval(get))
+	case "||":
+		return exprTruthy(x) || exprTruthy(n.y.eval(get))
+	}
+
+	y := n.y.eval(get)
+
+	switch n.op {
+	case "==":
+		return exprCompare(x, y) == 0
+	case "!=":
+		return exprCompare(x, y) != 0
+	case "<":
+		return exprCompare(x, y) == -1
+	case "<=":
+		c := exprCompare(x, y)
+		return c == -1 || c == 0
+	case ">":
+		return exprCompare(x, y) == 1
+	case ">=":
+		c := exprCompare(x, y)
+		return c == 1 || c == 0
+	}
+
+	if n.op == "+" {
+		_, xString := x.(string)
+		_, yString := y.(string)
+		if xString || yString {
+			return exprString(x) + exprString(y)
+		}
+	}
+
+	a, aOk := x.(float64)
+	b, bOk := y.(float64)
+	if !aOk || !bOk {
+		return nil
+	}
+
+	switch n.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if b == 0 {
+			return nil
+		}
+		return a / b
+	case "%":
+		if b == 0 {
+			return nil
+		}
+		return math.Mod(a, b)
+	}
+
+	return nil
+}
+
+type exprCall struct {
+	fn   *exprFunction
+	args []exprNode
+}
+
+func (n *exprCall) eval(get func(splitKey []string) any) any {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(get)
+	}
+
+	return n.fn.call(args)
+}
+
+// exprValue converts a record value to an expression value: nil, bool, float64, string or time.Time
+func exprValue(value any) any {
+	switch v := value.(type) {
+	case nil, bool, float64, string, time.Time:
+		return v
+	case types.DateTime:
+		if v.IsZero() {
+			return nil
+		}
+		return v.Time()
+	case int, int64, int32, float32, uint, uint64, uint32:
+		return cast.ToFloat64(v)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// exprTruthy returns whether the value is true in a condition
+func exprTruthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case time.Time:
+		return !v.IsZero()
+	}
+
+	return true
+}
+
+// exprString converts the value to a string, nil is an empty string
+func exprString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// exprNumber converts the value to a number
+func exprNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// exprInt converts the value to an int, false for NaN, infinities and the numbers out of the int32 range
+func exprInt(value any) (int, bool) {
+	v, ok := exprNumber(value)
+	if !ok || !(v >= math.MinInt32 && v <= math.MaxInt32) {
+		return 0, false
+	}
+
+	return int(v), true
+}
+
+// exprTime converts the value to a time
+func exprTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		d, err := types.ParseDateTime(v)
+		return d.Time(), err == nil && !d.IsZero()
+	}
+
+	return time.Time{}, false
+}
+
+// exprCompare compares the values, -1, 0 or 1, and 2 if they cannot be compared
+func exprCompare(x, y any) int {
+	if x == nil || y == nil {
+		if x == nil && y == nil {
+			return 0
+		}
+		return 2
+	}
+
+	switch a := x.(type) {
+	case float64:
+		if b, ok := exprNumber(y); ok {
+			return exprCompareOrdered(a, b)
+		}
+	case string:
+		if b, ok := y.(string); ok {
+			return exprCompareOrdered(a, b)
+		}
+		if b, ok := y.(time.Time); ok {
+			if t, ok := exprTime(a); ok {
+				return exprCompareOrdered(t.UnixNano(), b.UnixNano())
+			}
+		}
+	case time.Time:
+		if b, ok := exprTime(y); ok {
+			return exprCompareOrdered(a.UnixNano(), b.UnixNano())
+		}
+	case bool:
+		if b, ok := y.(bool); ok && a == b {
+			return 0
+		}
+	}
+
+	return 2
+}
+
+func exprCompareOrdered[T float64 | string | int64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// exprFunction is a function of the expressions, maxArgs is -1 for variadic functions
+type exprFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(args []any) any
+}
+
+// errExprUnit is the error of an unknown date unit
+var errExprUnit = errors.New("unknown date unit")
+
+// errExprRange is the error of a number out of the range of the dates
+var errExprRange = errors.New("number out of range")
+
+// exprDuration returns the duration of amount units, months and years are not durations
+func exprDuration(amount float64, unit string) (time.Duration, error) {
+	var d float64
+	switch unit {
+	case "seconds":
+		d = amount * float64(time.Second)
+	case "minutes":
+		d = amount * float64(time.Minute)
+	case "hours":
+		d = amount * float64(time.Hour)
+	case "days":
+		d = amount * float64(24*time.Hour)
+	case "weeks":
+		d = amount * float64(7*24*time.Hour)
+	default:
+		return 0, errExprUnit
+	}
+
+	// NaN, infinities and overflows have no duration
+	if !(d >= math.MinInt64 && d < math.MaxInt64) {
+		return 0, errExprRange
+	}
+
+	return time.Duration(d), nil
+}
+
+// exprNumberFunction returns a function of a number
+func exprNumberFunction(fn func(float64) float64) *exprFunction {
+	return &exprFunction{minArgs: 1, maxArgs: 1, call: func(args []any) any {
+		if v, ok := exprNumber(args[0]); ok {
+			return fn(v)
+		}
+		return nil
+	}}
+}
+
+// exprStringFunction returns a function of a string
+func exprStringFunction(fn func(string) any) *exprFunction {
+	return &exprFunction{minArgs: 1, maxArgs: 1, call: func(args []any) any {
+		return fn(exprString(args[0]))
+	}}
+}
+
+// exprFunctions are the functions of the expressions
+var exprFunctions = map[string]*exprFunction{
+	// if(condition, then, else)
+	"if": {minArgs: 3, maxArgs: 3, call: func(args []any) any {
+		if exprTruthy(args[0]) {
+			return args[1]
+		}
+		return args[2]
+	}},
+	// coalesce(values...) returns the first non empty value
+	"coalesce": {minArgs: 1, maxArgs: -1, call: func(args []any) any {
+		for _, arg := range args {
+			if arg != nil && arg != "" {
+				return arg
+			}
+		}
+		return nil
+	}},
+	// concat(values...) joins the values, null values are empty
+	"concat": {minArgs: 1, maxArgs: -1, call: func(args []any) any {
+		var b strings.Builder
+		for _, arg := range args {
+			b.WriteString(exprString(arg))
+		}
+		return b.String()
+	}},
+	"upper": exprStringFunction(func(s string) any { return strings.ToUpper(s) }),
+	"lower": exprStringFunction(func(s string) any { return strings.ToLower(s) }),
+	"trim":  exprStringFunction(func(s string) any { return strings.TrimSpace(s) }),
+	"len":   exprStringFunction(func(s string) any { return float64(utf8.RuneCountInString(s)) }),
+	// substr(value, start, length) counts in characters
+	"substr": {minArgs: 2, maxArgs: 3, call: func(args []any) any {
+		runes := []rune(exprString(args[0]))
+		start, ok := exprInt(args[1])
+		if !ok || start < 0 || start > len(runes) {
+			return nil
+		}
+
+		end := len(runes)
+		if len(args) == 3 {
+			// the lengths past the end are clamped
+			length, ok := exprNumber(args[2])
+			if !ok || !(length >= 0) {
+				return nil
+			}
+			if length < float64(end-start) {
+				end = start + int(length)
+			}
+		}
+
+		return string(runes[start:end])
+	}},
+	"string": {minArgs: 1, maxArgs: 1, call: func(args []any) any {
+		return exprString(args[0])
+	}},
+	"number": {minArgs: 1, maxArgs: 1, call: func(args []any) any {
+		if v, ok := exprNumber(args[0]); ok {
+			return v
+		}
+		return nil
+	}},
+	"abs":   exprNumberFunction(math.Abs),
+	"floor": exprNumberFunction(math.Floor),
+	"ceil":  exprNumberFunction(math.Ceil),
+	// round(value, decimals) rounds half away from zero, decimals defaults to 0
+	"round": {minArgs: 1, maxArgs: 2, call: func(args []any) any {
+		v, ok := exprNumber(args[0])
+		if !ok {
+			return nil
+		}
+
+		decimals := 0.0
+		if len(args) == 2 {
+			if decimals, ok = exprNumber(args[1]); !ok {
+				return nil
+			}
+		}
+
+		pow := math.Pow(10, math.Trunc(decimals))
+		return math.Round(v*pow) / pow
+	}},
+	"min": {minArgs: 1, maxArgs: -1, call: func(args []any) any {
+		return exprReduceNumbers(args, math.Min)
+	}},
+	"max": {minArgs: 1, maxArgs: -1, call: func(args []any) any {
+		return exprReduceNumbers(args, math.Max)
+	}},
+	// now() is the current UTC time
+	"now": {minArgs: 0, maxArgs: 0, call: func(args []any) any {
+		return time.Now().UTC()
+	}},
+	// date(value) parses a date
+	"date": {minArgs: 1, maxArgs: 1, call: func(args []any) any {
+		if t, ok := exprTime(args[0]); ok {
+			return t
+		}
+		return nil
+	}},
+	// dateAdd(date, amount, unit) adds amount seconds, minutes, hours, days, weeks, months or years to the date
+	"dateAdd": {minArgs: 3, maxArgs: 3, call: func(args []any) any {
+		t, ok := exprTime(args[0])
+		amount, amountOk := exprNumber(args[1])
+		if !ok || !amountOk {
+			return nil
+		}
+
+		switch unit := exprString(args[2]); unit {
+		case "months", "years":
+			n, ok := exprInt(args[1])
+			if !ok {
+				return nil
+			}
+
+			if unit == "months" {
+				return t.AddDate(0, n, 0)
+			}
+			return t.AddDate(n, 0, 0)
+		default:
+			d, err := exprDuration(amount, unit)
+			if err != nil {
+				return nil
+			}
+			return t.Add(d)
+		}
+	}},
+	// dateDiff(a, b, unit) is a - b in seconds, minutes, hours, days or weeks
+	"dateDiff": {minArgs: 3, maxArgs: 3, call: func(args []any) any {
+		a, aOk := exprTime(args[0])
+		b, bOk := exprTime(args[1])
+		unit, err := exprDuration(1, exprString(args[2]))
+		if !aOk || !bOk || err != nil {
+			return nil
+		}
+
+		return float64(a.Sub(b)) / float64(unit)
+	}},
+}
+
+// exprReduceNumbers reduces the numbers with fn, nil if a value is not a number
+func exprReduceNumbers(args []any, fn func(float64, float64) float64) any {
+	result, ok := exprNumber(args[0])
+	if !ok {
+		return nil
+	}
+
+	for _, arg := range args[1:] {
+		v, ok := exprNumber(arg)
+		if !ok {
+			return nil
+		}
+		result = fn(result, v)
+	}
+
+	return result
+}
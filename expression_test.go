@@ -0,0 +1,175 @@
+package pocketexport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func Test_ParseExpression(t *testing.T) {
+	created, _ := types.ParseDateTime("2023-06-11 10:00:00.000Z")
+	values := map[string]any{
+		"quantity":       3,
+		"price":          2.5,
+		"paid":           true,
+		"firstName":      "Nguyễn",
+		"lastName":       "An",
+		"created":        created,
+		"author.name":    "test1",
+		"empty":          "",
+		"missing.record": nil,
+	}
+	get := func(splitKey []string) any {
+		return values[strings.Join(splitKey, ".")]
+	}
+
+	scenarios := []struct {
+		expression string
+		expect     any
+		fields     []string
+	}{
+		{`quantity * price`, 7.5, []string{"quantity", "price"}},
+		{`quantity + price * 2 - 1`, 7.0, nil},
+		{`(quantity + 1) / 2 % 3`, 2.0, nil},
+		{`-quantity`, -3.0, nil},
+		{`quantity / 0`, nil, nil},
+		{`concat(firstName, " ", lastName)`, "Nguyễn An", []string{"firstName", "lastName"}},
+		{`firstName + ' ' + quantity`, "Nguyễn 3", nil},
+		{`if(paid, "Yes", "No")`, "Yes", []string{"paid"}},
+		{`if(!paid || quantity > 5, "Yes", "No")`, "No", nil},
+		{`quantity >= 3 && price < 3 && price != 2`, true, nil},
+		{`author.name == "test1"`, true, []string{"author.name"}},
+		{`coalesce(empty, missing.record, "none")`, "none", []string{"empty", "missing.record"}},
+		{`upper(substr(author.name, 0, 4)) + len(lastName)`, "TEST2", nil},
+		{`round(price * 1.111, 2)`, 2.78, nil},
+		{`max(quantity, price, 4)`, 4.0, nil},
+		{`dateAdd(created, 1, "months")`, created.Time().AddDate(0, 1, 0), []string{"created"}},
+		{`dateDiff(dateAdd(created, 36, "hours"), created, "days")`, 1.5, nil},
+		{`created < date("2023-06-12")`, true, nil},
+		{`number("12") + 1`, 13.0, nil},
+		{`quantity * firstName`, nil, nil},
+		{`null == missing.record`, true, nil},
+		{`substr("abc", "1e30")`, nil, nil},
+		{`substr("abc", "NaN")`, nil, nil},
+		{`substr("abc", 0, "NaN")`, nil, nil},
+		{`substr("abc", 1, "1e30")`, "bc", nil},
+		{`dateAdd(created, "1e30", "months")`, nil, nil},
+		{`dateAdd(created, "NaN", "days")`, nil, nil},
+		{`dateAdd(created, "1e30", "seconds")`, nil, nil},
+	}
+
+	for i, s := range scenarios {
+		expression, err := ParseExpression(s.expression)
+		if err != nil {
+			t.Fatalf("(%d) %v", i, err)
+		}
+
+		if v := expression.Eval(get); v != s.expect {
+			t.Fatalf("(%d) expect %v (%T), got %v (%T)", i, s.expect, s.expect, v, v)
+		}
+
+		if s.fields != nil && strings.Join(expression.Fields(), ",") != strings.Join(s.fields, ",") {
+			t.Fatalf("(%d) expect fields %v, got %v", i, s.fields, expression.Fields())
+		}
+	}
+
+	invalids := []string{
+		``,
+		`quantity *`,
+		`(quantity`,
+		`quantity price`,
+		`unknown(quantity)`,
+		`if(paid, "Yes")`,
+		`"unterminated`,
+		`quantity # 2`,
+		`1.2.3`,
+		strings.Repeat("1+", expressionMaxLength),
+	}
+
+	for i, s := range invalids {
+		if _, err := ParseExpression(s); err == nil {
+			t.Fatalf("(%d) expect error for %q", i, s)
+		}
+	}
+}
+
+func Test_pocketExport_ExpressionHeaders(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{"expression": `concat(message, " - ", author.name)`, "header": "nội dung"},
+		map[string]any{
+			"expression": `if(author.name == "test1", "yes", "no")`,
+			"header":     "test1",
+			"valueMap":   map[string]any{"yes": "Có"},
+		},
+		map[string]any{"expression": `dateAdd(created, -1, "years") < created`, "header": "năm"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "nội dung,test1,năm\n" +
+		"test1 - test1,Có,true\n" +
+		"test2 - test2,no,true\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	scenarios := []struct {
+		expression string
+		code       string
+	}{
+		{`concat(message`, "validation_invalid_expression"},
+		{`author.tokenKey + ""`, "validation_field_not_visible"},
+	}
+
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		record.Set(HeadersField, []any{map[string]any{"expression": s.expression, "header": "x"}})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+
+	// unknown field
+	record = getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{map[string]any{"expression": `unknown * 2`, "header": "x"}})
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should not resolve the unknown field")
+	}
+
+	// masked field
+	CollectionFieldPolicy("users", AnyAuthCollection, FieldPolicy{
+		Masks: map[string]Mask{"email": MaskEmail},
+	})(&exportService.config)
+	record = getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(HeadersField, []any{map[string]any{"expression": `lower(author.email)`, "header": "x"}})
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should not compute the masked field")
+	} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != "validation_field_not_allowed" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package pocketexport
+
+import (
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/resolvers"
+)
+
+// exportFieldsMaxDepth is the maximum relation depth of the exportable fields,
+// it is the same as the maximum nested expand depth of pocketbase
+const exportFieldsMaxDepth = 6
+
+// ExportField represents an exportable field of a collection
+type ExportField struct {
+	// FieldName is the full field path that can be used as HeaderItem.FieldName
+	FieldName string `json:"fieldName"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	System    bool   `json:"system"`
+	// Fields are the exportable fields of the related collection,
+	// only single relations are expanded
+	Fields []*ExportField `json:"fields,omitempty"`
+}
+
+// exportFieldsSystemFields returns the system fields with their types
+func exportFieldsSystemFields(collection *models.Collection) []*schema.SchemaField {
+	fields := []*schema.SchemaField{
+		{Name: schema.FieldNameId, Type: schema.FieldTypeText},
+		{Name: schema.FieldNameCreated, Type: schema.FieldTypeDate},
+		{Name: schema.FieldNameUpdated, Type: schema.FieldTypeDate},
+	}
+
+	if collection.IsAuth() {
+		fields = append(
+			fields,
+			&schema.SchemaField{Name: schema.FieldNameUsername, Type: schema.FieldTypeText},
+			&schema.SchemaField{Name: schema.FieldNameEmail, Type: schema.FieldTypeEmail},
+			&schema.SchemaField{Name: schema.FieldNameEmailVisibility, Type: schema.FieldTypeBool},
+			&schema.SchemaField{Name: schema.FieldNameVerified, Type: schema.FieldTypeBool},
+		)
+	}
+
+	return fields
+}
+
+// exportableFields returns the tree of exportable fields of the collection
+// for the auth record or admin, single relations are expanded up to depth.
+func (p *PocketExport) exportableFields(
+	collection *models.Collection,
+	authRecord *models.Record,
+	admin *models.Admin,
+	depth int,
+) ([]*ExportField, error) {
+	dao := p.app.Dao()
+	fieldResolver := resolvers.NewRecordFieldResolver(
+		dao,
+		collection,
+		&models.RequestInfo{
+			Method:     http.MethodGet,
+			Query:      map[string]any{},
+			Data:       map[string]any{},
+			Headers:    map[string]any{},
+			AuthRecord: authRecord,
+			Admin:      admin,
+		},
+		false,
+	)
+
+	var walk func(c *models.Collection, prefix string, depth int) ([]*ExportField, error)
+	walk = func(c *models.Collection, prefix string, depth int) ([]*ExportField, error) {
+		systemFields := exportFieldsSystemFields(c)
+		fields := append(systemFields, c.Schema.Fields()...)
+		result := make([]*ExportField, 0, len(fields))
+		policy := p.config.fieldPolicy(c.Name, authRecord)
+
+		for i, field := range fields {
+			fieldName := prefix + field.Name
+
+			// skip fields that validateAndFill would reject
+			if r, err := fieldResolver.Resolve(fieldName); err != nil || r.MultiMatchSubQuery != nil {
+				continue
+			}
+
+			if policy != nil && !policy.allows(field.Name) {
+				continue
+			}
+
+			item := &ExportField{
+				FieldName: fieldName,
+				Name:      field.Name,
+				Type:      field.Type,
+				System:    i < len(systemFields),
+			}
+			result = append(result, item)
+
+			if field.Type != schema.FieldTypeRelation || depth <= 0 {
+				continue
+			}
+
+			field.InitOptions()
+			options, ok := field.Options.(*schema.RelationOptions)
+			if !ok || options.MaxSelect == nil || *options.MaxSelect != 1 {
+				continue
+			}
+
+			relCollection, err := dao.FindCollectionByNameOrId(options.CollectionId)
+			if err != nil {
+				return nil, err
+			}
+
+			// related records of admin only collections are never expanded for non admins
+			if admin == nil && relCollection.ViewRule == nil {
+				continue
+			}
+
+			if item.Fields, err = walk(relCollection, fieldName+".", depth-1); err != nil {
+				return nil, err
+			}
+		}
+
+		return result, nil
+	}
+
+	return walk(collection, "", depth)
+}
@@ -0,0 +1,417 @@
+package pocketexport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// templateMaxLength is the maximum length of a header template
+const templateMaxLength = 1000
+
+// formatMaxDecimals is the maximum number of decimals of the formatted numbers
+const formatMaxDecimals = 20
+
+var (
+	errInvalidTemplate = validation.NewError("validation_invalid_template", "invalid template {{.template}}: {{.error}}")
+	errInvalidLocale   = validation.NewError("validation_invalid_locale", "invalid locale {{.locale}}")
+	errInvalidCurrency = validation.NewError("validation_invalid_currency", "invalid currency {{.currency}}")
+	errInvalidDecimals = validation.NewError("validation_invalid_decimals", "the decimals must be between 0 and {{.max}}")
+)
+
+// strftimeDirectives are the Go layouts of the supported strftime directives
+var strftimeDirectives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// goDateLayout returns the Go layout of the date layout, the layouts with a % are strftime-like
+// and the unknown directives are kept as is, eg. "%d/%m/%Y %H:%M" is "02/01/2006 15:04"
+func goDateLayout(layout string) string {
+	if layout == "" {
+		return time.RFC3339
+	}
+
+	if !strings.Contains(layout, "%") {
+		return layout
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == '%' && i+1 < len(layout) {
+			if v, ok := strftimeDirectives[layout[i+1]]; ok {
+				b.WriteString(v)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(layout[i])
+	}
+
+	return b.String()
+}
+
+// parseLocale parses the BCP 47 locale, eg. "vi" or "de-DE"
+func parseLocale(locale string) (language.Tag, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, errInvalidLocale.SetParams(map[string]any{"locale": locale})
+	}
+
+	return tag, nil
+}
+
+// validateFormat validates the timezone, locale and currency of the header
+func (i *HeaderItem) validateFormat() error {
+	if i.Timezone != "" {
+		if _, err := time.LoadLocation(i.Timezone); err != nil {
+			return err
+		}
+	}
+
+	if i.Locale != "" {
+		if _, err := parseLocale(i.Locale); err != nil {
+			return err
+		}
+	}
+
+	if i.Currency != "" {
+		if _, err := currency.ParseISO(i.Currency); err != nil {
+			return errInvalidCurrency.SetParams(map[string]any{"currency": i.Currency})
+		}
+	}
+
+	if i.Decimals != nil && (*i.Decimals < 0 || *i.Decimals > formatMaxDecimals) {
+		return errInvalidDecimals.SetParams(map[string]any{"max": formatMaxDecimals})
+	}
+
+	return nil
+}
+
+// formatsNumbers reports whether the numbers of the header are formatted
+func (i *HeaderItem) formatsNumbers() bool {
+	return i.Locale != "" || i.Decimals != nil || i.Currency != ""
+}
+
+// numberPrinter returns the printer of the header locale, the invalid locales are undetermined
+func (i *HeaderItem) numberPrinter() *message.Printer {
+	if i.printer == nil {
+		tag, _ := parseLocale(i.Locale)
+		i.printer = message.NewPrinter(tag)
+	}
+
+	return i.printer
+}
+
+// formatNumber formats the number with the locale, decimals and currency of the header,
+// the currency symbol is prepended and the decimals default to the currency ones
+func (i *HeaderItem) formatNumber(value any) (string, bool) {
+	var v float64
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		v = cast.ToFloat64(value)
+	default:
+		return "", false
+	}
+
+	p := i.numberPrinter()
+	unit, err := currency.ParseISO(i.Currency)
+	hasCurrency := i.Currency != "" && err == nil
+
+	var options []number.Option
+	decimals := i.Decimals
+	if decimals == nil && hasCurrency {
+		scale, _ := currency.Standard.Rounding(unit)
+		decimals = &scale
+	}
+	if decimals != nil {
+		options = append(options, number.MinFractionDigits(*decimals), number.MaxFractionDigits(*decimals))
+	}
+
+	formatted := p.Sprint(number.Decimal(v, options...))
+	if hasCurrency {
+		formatted = p.Sprint(currency.Symbol(unit)) + " " + formatted
+	}
+
+	return formatted, true
+}
+
+// HeaderTemplateData is the data of the header templates
+type HeaderTemplateData struct {
+	// Value is the formatted value of the header, masked by the field policies of the owner
+	Value any
+	// Record is the exported record like the records api returns it to the owner,
+	// with the related records of the headers in expand
+	Record map[string]any
+}
+
+// templateTime converts the value to a time, the strings can be RFC3339 or pocketbase dates
+func templateTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case types.DateTime:
+		return v.Time(), !v.IsZero()
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+
+		d, err := types.ParseDateTime(v)
+		return d.Time(), err == nil && !d.IsZero()
+	}
+
+	return time.Time{}, false
+}
+
+// headerTemplateFuncs are the functions of the header templates
+var headerTemplateFuncs = template.FuncMap{
+	// date formats the date with the Go layout, eg. {{date "02/01/2006" .Value}}
+	"date": func(layout string, value any) string {
+		t, ok := templateTime(value)
+		if !ok {
+			return ""
+		}
+
+		return t.Format(layout)
+	},
+	// number formats the number with the decimals, eg. {{number 2 .Value}}
+	"number": func(decimals int, value any) (string, error) {
+		if err := validateTemplateDecimals(decimals); err != nil {
+			return "", err
+		}
+
+		v, err := cast.ToFloat64E(value)
+		if err != nil {
+			return "", nil
+		}
+
+		return strconv.FormatFloat(v, 'f', decimals, 64), nil
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// join joins the values with the separator, eg. {{join ", " .Record.tags}}
+	"join": func(sep string, value any) string {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Sprintf("%v", value)
+		}
+
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprintf("%v", rv.Index(i).Interface())
+		}
+
+		return strings.Join(parts, sep)
+	},
+	// default returns the default value if the value is empty, eg. {{.Value | default "N/A"}}
+	"default": func(def any, value any) any {
+		if value == nil || value == "" {
+			return def
+		}
+
+		return value
+	},
+}
+
+// ParsedTemplate returns the parsed template of the header, nil if the header has no template
+func (i *HeaderItem) ParsedTemplate() (*template.Template, error) {
+	if i.Template == "" || i.template != nil {
+		return i.template, nil
+	}
+
+	errInvalid := func(err error) error {
+		return errInvalidTemplate.SetParams(map[string]any{
+			"template": i.Template,
+			"error":    err.Error(),
+		})
+	}
+
+	if len(i.Template) > templateMaxLength {
+		return nil, errInvalid(fmt.Errorf("the template is longer than %d characters", templateMaxLength))
+	}
+
+	tmpl, err := template.New("header").Funcs(headerTemplateFuncs).Option("missingkey=zero").Parse(i.Template)
+	if err != nil {
+		return nil, errInvalid(err)
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+
+		if err := validateTemplateNode(t.Tree.Root); err != nil {
+			return nil, errInvalid(err)
+		}
+	}
+	i.template = tmpl
+
+	return tmpl, nil
+}
+
+// validateTemplateDecimals validates the decimals of the number template function
+func validateTemplateDecimals(decimals int) error {
+	if decimals < 0 || decimals > formatMaxDecimals {
+		return fmt.Errorf("the number decimals must be between 0 and %d", formatMaxDecimals)
+	}
+
+	return nil
+}
+
+// validateTemplateNode validates the constant arguments of the template functions of the node
+func validateTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			if err := validateTemplateNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return validateTemplateNode(n.Pipe)
+	case *parse.IfNode:
+		return validateTemplateBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return validateTemplateBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return validateTemplateBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		return validateTemplateNode(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, cmd := range n.Cmds {
+			if err := validateTemplateNode(cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			if fn, ok := n.Args[0].(*parse.IdentifierNode); ok && fn.Ident == "number" {
+				if decimals, ok := n.Args[1].(*parse.NumberNode); ok {
+					if !decimals.IsInt {
+						return validateTemplateDecimals(-1)
+					}
+
+					if err := validateTemplateDecimals(int(decimals.Int64)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, arg := range n.Args {
+			if err := validateTemplateNode(arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateTemplateBranch validates the pipeline and the lists of the if, range and with nodes
+func validateTemplateBranch(n *parse.BranchNode) error {
+	if err := validateTemplateNode(n.Pipe); err != nil {
+		return err
+	}
+
+	if err := validateTemplateNode(n.List); err != nil {
+		return err
+	}
+
+	return validateTemplateNode(n.ElseList)
+}
+
+// renderHeaderTemplate renders the header template, the missing values render empty
+// and the errors of the template functions are returned
+func renderHeaderTemplate(tmpl *template.Template, data *HeaderTemplateData) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, data); err != nil {
+		var execErr template.ExecError
+		if errors.As(err, &execErr) && errors.Unwrap(execErr.Err) == nil {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// exportTemplateRecord returns the record data of the header templates, the record is exported
+// like the records api does and the field policies of the owner are applied to it and to its expands
+func (s *PocketExport) exportTemplateRecord(authRecord *models.Record, record *models.Record) map[string]any {
+	data := record.PublicExport()
+	policy := s.config.fieldPolicy(record.Collection().Name, authRecord)
+
+	for key, value := range data {
+		if key == schema.FieldNameExpand {
+			expand := map[string]any{}
+			for name, v := range cast.ToStringMap(value) {
+				switch v := v.(type) {
+				case *models.Record:
+					expand[name] = s.exportTemplateRecord(authRecord, v)
+				case []*models.Record:
+					records := make([]map[string]any, len(v))
+					for j := range v {
+						records[j] = s.exportTemplateRecord(authRecord, v[j])
+					}
+					expand[name] = records
+				}
+			}
+			data[key] = expand
+			continue
+		}
+
+		if policy == nil {
+			continue
+		}
+
+		if !policy.allows(key) {
+			delete(data, key)
+		} else if mask := policy.Masks[key]; mask != nil {
+			data[key] = mask(value)
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,303 @@
+package pocketexport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func Test_HeaderItem_ParsedTemplate(t *testing.T) {
+	created, _ := types.ParseDateTime("2023-06-11 10:00:00.000Z")
+	record := map[string]any{"name": "nguyễn văn a", "tags": []string{"a", "b"}, "price": 1234.5}
+
+	scenarios := []struct {
+		template string
+		value    any
+		expect   string
+	}{
+		{`{{upper .Record.name}} <{{.Value}}>`, "a@b.com", "NGUYỄN VĂN A <a@b.com>"},
+		{`{{date "02/01/2006 15:04" .Value}}`, created, "11/06/2023 10:00"},
+		{`{{date "02/01/2006 15:04" .Value}}`, "2023-06-11T17:00:00+07:00", "11/06/2023 17:00"},
+		{`{{number 2 .Record.price}}`, nil, "1234.50"},
+		{`{{join ", " .Record.tags}}`, nil, "a, b"},
+		{`{{.Value | default "N/A"}}`, "", "N/A"},
+		{`{{.Value | lower | default "N/A"}}`, "ABC", "abc"},
+		{`{{.Record.missing.name}}`, nil, ""},
+	}
+
+	for i, s := range scenarios {
+		item := &HeaderItem{Template: s.template}
+		tmpl, err := item.ParsedTemplate()
+		if err != nil {
+			t.Fatalf("(%d) %v", i, err)
+		}
+
+		if v, err := renderHeaderTemplate(tmpl, &HeaderTemplateData{Value: s.value, Record: record}); err != nil {
+			t.Fatalf("(%d) %v", i, err)
+		} else if v != s.expect {
+			t.Fatalf("(%d) expect %q, got %q", i, s.expect, v)
+		}
+	}
+
+	// the decimals of the record are checked when rendering
+	item := &HeaderItem{Template: `{{number .Record.decimals .Value}}`}
+	tmpl, err := item.ParsedTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderHeaderTemplate(tmpl, &HeaderTemplateData{Value: 1, Record: map[string]any{"decimals": 200000000}}); err == nil {
+		t.Fatal("should fail on too many decimals")
+	}
+
+	for i, s := range []string{
+		`{{.Value`,
+		`{{unknown .Value}}`,
+		`{{number 200000000 .Value}}`,
+		`{{.Value | number -1}}`,
+		`{{number 1.5 .Value}}`,
+		`{{if .Value}}{{else}}{{upper (number 21 .Value)}}{{end}}`,
+	} {
+		item := &HeaderItem{Template: s}
+		if _, err := item.ParsedTemplate(); err == nil {
+			t.Fatalf("(%d) expect error for %q", i, s)
+		}
+	}
+}
+
+func Test_pocketExport_TemplateHeaders(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(HeadersField, []any{
+		map[string]any{
+			"fieldName": "author.name",
+			"header":    "tác giả",
+			"template":  `{{upper .Value}} <{{.Record.expand.author.email | default "ẩn"}}>`,
+		},
+	})
+
+	// the field policies apply to the template record
+	CollectionFieldPolicy("users", "users", FieldPolicy{Deny: []string{"email"}})(&exportService.config)
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner cannot view the author of the second message
+	expect := "tác giả\n" +
+		"TEST1 <ẩn>\n" +
+		"\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the template renders the masked value
+	CollectionFieldPolicy("users", "users", FieldPolicy{Masks: map[string]Mask{"email": MaskEmail}})(&exportService.config)
+	record = getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(FilterField, `author = "vzz4enej24xtni9"`)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "author.email", "header": "email", "template": `a@{{printf "%x" .Value}}`},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = "email\n" +
+		fmt.Sprintf("a@%x\n", "t****@gmail.com")
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// admin
+	record = getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{
+			"fieldName": "author.name",
+			"header":    "tác giả",
+			"template":  `{{.Value}} <{{.Record.expand.author.email}}>`,
+		},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = "tác giả\n" +
+		"test1 <test1@gmail.com>\n" +
+		"test2 <test2@gmail.com>\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the errors of the template functions fail the export
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "x", "template": `{{number (len (printf "%s%s" .Record.id .Record.id)) .Value}}`},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := exportService.GenerateExportOutput(buf, export); err == nil || !strings.Contains(err.Error(), "decimals") {
+		t.Fatalf("expect decimals error, got %v", err)
+	}
+
+	// invalid templates
+	for i, s := range []string{`{{.Value`, `{{number 200000000 .Value}}`} {
+		record.Set(HeadersField, []any{
+			map[string]any{"fieldName": "message", "header": "x", "template": s},
+		})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != "validation_invalid_template" {
+			t.Fatalf("(%d) %v", i, err)
+		}
+	}
+}
+
+func Test_HeaderItem_FormatLocale(t *testing.T) {
+	created, _ := types.ParseDateTime("2023-06-11 10:00:00.000Z")
+	two := 2
+	zero := 0
+
+	scenarios := []struct {
+		item   HeaderItem
+		value  any
+		expect any
+	}{
+		{HeaderItem{}, created, "2023-06-11T10:00:00Z"},
+		{HeaderItem{DateLayout: "02/01/2006 15:04"}, created, "11/06/2023 10:00"},
+		{HeaderItem{DateLayout: "%d/%m/%Y %H:%M %%", Timezone: "Asia/Ho_Chi_Minh"}, created, "11/06/2023 17:00 %"},
+		{HeaderItem{DateLayout: "%A %e %B %Q"}, created.Time(), "Sunday 11 June %Q"},
+		{HeaderItem{}, 1234567.891, 1234567.891},
+		{HeaderItem{Locale: "vi"}, 1234567.891, "1.234.567,891"},
+		{HeaderItem{Locale: "de-DE", Decimals: &two}, 1234567.891, "1.234.567,89"},
+		{HeaderItem{Locale: "en-US", Decimals: &two}, 5, "5.00"},
+		{HeaderItem{Decimals: &zero}, 1234.5, "1,234"},
+		{HeaderItem{Locale: "vi", Currency: "VND"}, 1500000.0, "₫ 1.500.000"},
+		{HeaderItem{Locale: "de", Currency: "EUR"}, 1234.5, "€ 1.234,50"},
+		{HeaderItem{Locale: "vi", ValueMap: map[string]any{"1": 1000}}, 1, "1.000"},
+		{HeaderItem{Locale: "vi"}, "1234", "1234"},
+	}
+
+	for i, s := range scenarios {
+		if v := s.item.Format(s.value); v != s.expect {
+			t.Fatalf("(%d) expect %v (%T), got %v (%T)", i, s.expect, s.expect, v, v)
+		}
+	}
+
+	for i, item := range []HeaderItem{{Locale: "xx-yy-zz!!"}, {Currency: "ABCD"}, {Timezone: "Mars/Olympus"}} {
+		if err := item.validateFormat(); err == nil {
+			t.Fatalf("(%d) expect error for %v", i, item)
+		}
+	}
+}
+
+func Test_pocketExport_HeaderDefaults(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(DefaultTimezoneField, "Europe/Berlin")
+	record.Set(LocaleField, "de")
+	record.Set(FilterField, `message = "test1"`)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "created", "header": "utc", "timezone": "UTC", "dateLayout": "%Y-%m-%d %H:%M"},
+		map[string]any{"fieldName": "created", "header": "berlin", "dateLayout": "%Y-%m-%d %H:%M"},
+		map[string]any{"expression": "len(message) * 1000.5", "header": "số", "decimals": 1},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := export.Headers()
+	if headers[0].Timezone != "UTC" || headers[1].Timezone != "Europe/Berlin" || headers[2].Locale != "de" {
+		t.Fatalf("should apply the export defaults, got %v", headers)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "utc,berlin,số\n" +
+		"2023-06-11 08:48,2023-06-11 10:48,\"5.002,5\"\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	scenarios := []struct {
+		field string
+		value string
+		code  string
+	}{
+		{DefaultTimezoneField, "Mars/Olympus", ""},
+		{LocaleField, "xx-yy-zz!!", "validation_invalid_locale"},
+	}
+
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(s.field, s.value)
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[s.field]; !ok {
+			t.Fatalf("(%d) expect %s error, got %v", i, s.field, err)
+		} else if e, ok := e.(validation.Error); s.code != "" && (!ok || e.Code() != s.code) {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+
+	headerScenarios := []struct {
+		header map[string]any
+		code   string
+	}{
+		{map[string]any{"currency": "ABCD"}, "validation_invalid_currency"},
+		{map[string]any{"decimals": -5}, "validation_invalid_decimals"},
+		{map[string]any{"decimals": 21}, "validation_invalid_decimals"},
+	}
+
+	for i, s := range headerScenarios {
+		s.header["fieldName"] = "message"
+		s.header["header"] = "x"
+		record = getExportRecord(t, testApp)
+		record.Set(HeadersField, []any{s.header})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+}
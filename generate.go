@@ -1,7 +1,9 @@
 package pocketexport
 
 import (
+	"bufio"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,11 +12,17 @@ import (
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
 	"github.com/pocketbase/pocketbase/resolvers"
+	"github.com/pocketbase/pocketbase/tools/list"
 	"github.com/pocketbase/pocketbase/tools/search"
 	"github.com/xuri/excelize/v2"
 )
 
+// generateExportPerPage is the number of records fetched per page,
+// the search provider does not allow more than search.MaxPerPage.
+const generateExportPerPage = search.MaxPerPage
+
 // GenerateExportOutput generates the export output.
 func (s *PocketExport) generateExportOutput(dst io.Writer, export *Export) (err error) {
 	filter := export.GetString(FilterField)
@@ -25,13 +33,52 @@ func (s *PocketExport) generateExportOutput(dst io.Writer, export *Export) (err
 		err = s.generateExportCSVOutput(dst, filter, sort, export)
 	case FormatXLSX:
 		err = s.generateExportXLSXOutput(dst, filter, sort, export)
+	case FormatJSON:
+		err = s.generateExportJSONOutput(dst, filter, sort, export)
 	}
 
 	return
 }
 
-// generateExportGetRecordValue is used to get the value of a record.
-func (s *PocketExport) generateExportGetRecordValue(r *models.Record, item *HeaderItem, splitKey []string) any {
+// generateExportGetRecordValue is used to get the value of a record, the values of a multi-valued
+// path are masked one by one and aggregated, masked is true if the mask was applied.
+func (s *PocketExport) generateExportGetRecordValue(
+	r *models.Record,
+	item *HeaderItem,
+	splitKey []string,
+	mask Mask,
+) (value any, masked bool) {
+	// the back-relations that are only counted expand to their count
+	if count, ok := r.Expand()[splitKey[0]].(backRelationCount); ok {
+		if item.Aggregate == AggregateExists {
+			return count > 0, mask != nil
+		}
+
+		return int(count), mask != nil
+	}
+
+	values, multi := s.generateExportGetRecordValues(r, splitKey)
+	if !multi && item.Aggregate == "" {
+		value, ok := s.generateExportGetRawRecordValue(r, splitKey)
+		if !ok {
+			return "", false
+		}
+
+		return item.Format(value), false
+	}
+
+	if mask != nil {
+		for j := range values {
+			values[j] = mask(values[j])
+		}
+	}
+
+	return item.aggregate(values), mask != nil
+}
+
+// generateExportGetRawRecordValue returns the unformatted value of the field path
+// and false if the record or its nested record has no such field.
+func (s *PocketExport) generateExportGetRawRecordValue(r *models.Record, splitKey []string) (any, bool) {
 	nestedRecord := r
 	lenSplitKey := len(splitKey)
 
@@ -41,6 +88,11 @@ func (s *PocketExport) generateExportGetRecordValue(r *models.Record, item *Head
 			break
 		}
 
+		// the json fields are followed by their json path
+		if isJSONField(nestedRecord.Collection(), splitKey[k]) {
+			return generateExportGetJSONPathValue(nestedRecord, splitKey[k:])
+		}
+
 		r, ok := nestedRecord.Expand()[splitKey[k]]
 		if !ok || r == nil {
 			nestedRecord = nil
@@ -52,49 +104,131 @@ func (s *PocketExport) generateExportGetRecordValue(r *models.Record, item *Head
 
 	// cannot find the nested record
 	if nestedRecord == nil {
-		return ""
+		return nil, false
+	}
+
+	// auth records are exported like the records api does, eg. the email
+	// is hidden if it is not visible to the owner of the export
+	key := splitKey[lenSplitKey-1]
+	if strings.Contains(key, "[") && isJSONField(nestedRecord.Collection(), key) {
+		return generateExportGetJSONPathValue(nestedRecord, splitKey[lenSplitKey-1:])
+	}
+	if nestedRecord.Collection().IsAuth() {
+		value, ok := nestedRecord.PublicExport()[key]
+		return value, ok
 	}
 
 	// get the value
-	return item.Format(nestedRecord.Get(splitKey[lenSplitKey-1]))
+	return nestedRecord.Get(key), true
+}
+
+// generateExportGetJSONPathValue returns the first value of the json path, the segments start
+// with the json field, and false if the json path has no value.
+func generateExportGetJSONPathValue(r *models.Record, segments []string) (any, bool) {
+	values, _ := exportJSONPathValues(r, segments)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	return values[0], true
 }
 
-// generateExportGetHeaderSplitMap return the header split map from header map.
-func (s *PocketExport) generateExportGetHeaderSplitMap(headerMap []HeaderItem) map[string][]string {
+// generateExportGetExpressionValue evaluates the header expression with the record values.
+func (s *PocketExport) generateExportGetExpressionValue(r *models.Record, item *HeaderItem, expression *Expression) any {
+	value := expression.Eval(func(splitKey []string) any {
+		value, _ := s.generateExportGetRawRecordValue(r, splitKey)
+		return value
+	})
+
+	if value == nil {
+		return ""
+	}
+
+	return item.Format(value)
+}
+
+// generateExportGetHeaderSplitMap return the header split map from header map,
+// with the fields of the header expressions.
+func (s *PocketExport) generateExportGetHeaderSplitMap(collection *models.Collection, headerMap []HeaderItem) map[string][]string {
 	headerSplitMap := make(map[string][]string, len(headerMap))
 
 	for i := range headerMap {
 		item := &(headerMap)[i]
-		splitKey := strings.Split(item.FieldName, ".")
-		headerSplitMap[item.FieldName] = splitKey
+
+		// the expressions are validated with the export
+		fieldNames, _ := item.FieldNames()
+		for _, fieldName := range fieldNames {
+			headerSplitMap[fieldName] = strings.Split(backRelationFieldPath(collection, fieldName), ".")
+		}
 	}
 
 	return headerSplitMap
 }
 
-// generateExportGetExpandsFromHeaderSplitMap return the expands from header split map.
-func (s *PocketExport) generateExportGetExpandsFromHeaderSplitMap(headerSplitMap map[string][]string) []string {
+// generateExportGetExpandsFromHeaderSplitMap return the expands from header split map,
+// the relations of the paths without the json paths.
+func (s *PocketExport) generateExportGetExpandsFromHeaderSplitMap(
+	collection *models.Collection,
+	headerSplitMap map[string][]string,
+) []string {
 	expands := make([]string, 0, len(headerSplitMap))
 
 	for _, splitKey := range headerSplitMap {
-		expands = append(expands, strings.Join(splitKey[:len(splitKey)-1], "."))
+		depth := len(splitKey) - 1
+		if collections, _, err := exportFieldCollections(s.app.Dao(), collection, strings.Join(splitKey, ".")); err == nil {
+			depth = len(collections) - 1
+		}
+
+		expands = append(expands, strings.Join(splitKey[:depth], "."))
 	}
 
 	return expands
 }
 
-// generateExportOutputRecords generates the export output records.
-func (s *PocketExport) generateExportOutputRecords(
-	records *[]*models.Record,
+// generateExportGetExpands return the expands of the export headers and of its unwind relation.
+func (s *PocketExport) generateExportGetExpands(export *Export, headerSplitMap map[string][]string) []string {
+	expands := s.generateExportGetExpandsFromHeaderSplitMap(export.ExportCollection(), headerSplitMap)
+
+	if name := export.GetString(UnwindField); name != "" {
+		if field := export.ExportCollection().Schema.GetFieldByName(name); field != nil && field.Type == schema.FieldTypeRelation {
+			expands = append(expands, name)
+		}
+	}
+
+	return expands
+}
+
+// generateExportSearchProvider returns the search provider of the export records
+// with the export filter, sort and the collection export rule applied.
+func (s *PocketExport) generateExportSearchProvider(
 	filter string,
 	sort string,
 	export *Export,
-	page int,
-) error {
+) (*search.Provider, error) {
 	dao := s.app.Dao()
 
-	fieldResolver := resolvers.NewRecordFieldResolver(
-		dao,
+	filters, err := s.generateExportFilters(filter, export)
+	if err != nil {
+		return nil, err
+	}
+
+	searchProvider := search.NewProvider(s.exportFieldResolver(export)).
+		Query(dao.RecordQuery(export.ExportCollection())).
+		Filter(filters)
+
+	if sort != "" {
+		for _, sortField := range search.ParseSortFromString(sort) {
+			searchProvider.AddSort(sortField)
+		}
+	}
+
+	return searchProvider, nil
+}
+
+// exportFieldResolver returns the field resolver of the export collection for the export owner
+func (s *PocketExport) exportFieldResolver(export *Export) *resolvers.RecordFieldResolver {
+	return resolvers.NewRecordFieldResolver(
+		s.app.Dao(),
 		export.ExportCollection(),
 		&models.RequestInfo{
 			Method:     http.MethodGet,
@@ -106,33 +240,267 @@ func (s *PocketExport) generateExportOutputRecords(
 		},
 		false,
 	)
+}
 
-	searchProvider := search.NewProvider(fieldResolver).
-		Query(dao.RecordQuery(export.ExportCollection())).
+// generateExportFilters returns the filter of the export with the required filter
+// of the collection and the export rule of the owner.
+func (s *PocketExport) generateExportFilters(filter string, export *Export) ([]search.FilterData, error) {
+	filters := []search.FilterData{}
+	if filter != "" {
+		filters = append(filters, search.FilterData(filter))
+	}
+
+	// the required filter of the collection applies to everyone
+	if config, ok := s.config.collectionConfigs[export.ExportCollection().Name]; ok && config.RequiredFilter != "" {
+		filters = append(filters, search.FilterData(config.RequiredFilter))
+	}
+
+	// ensure that the user can export the collection
+	if export.Admin() == nil {
+		rule, err := s.collectionExportRule(export.ExportCollection())
+		if err != nil {
+			return nil, err
+		}
+
+		if rule == nil {
+			return nil, errAdminOnlyCollection
+		}
+
+		if *rule != "" {
+			filters = append(filters, search.FilterData(*rule))
+		}
+	}
+
+	return filters, nil
+}
+
+// generateExportOutputRecords generates the export output records.
+func (s *PocketExport) generateExportOutputRecords(
+	records *[]*models.Record,
+	filter string,
+	sort string,
+	export *Export,
+	page int,
+) error {
+	searchProvider, err := s.generateExportSearchProvider(filter, sort, export)
+	if err != nil {
+		return err
+	}
+
+	_, err = searchProvider.
 		Page(page).
-		PerPage(1000)
+		PerPage(generateExportPerPage).
+		SkipTotal(true).
+		Exec(records)
 
-	if filter != "" {
-		searchProvider.AddFilter(search.FilterData(filter))
+	return err
+}
+
+// generateExportEnrichRecords expands the relations and the back-relations needed by the export headers.
+func (s *PocketExport) generateExportEnrichRecords(records []*models.Record, export *Export, expands []string) error {
+	relations := make([]string, 0, len(expands))
+	backRelations := make([]string, 0, len(expands))
+	for _, expand := range expands {
+		if isBackRelationPath(export.ExportCollection(), expand) {
+			backRelations = append(backRelations, expand)
+		} else {
+			relations = append(relations, expand)
+		}
 	}
 
-	if sort != "" {
-		for _, sortField := range search.ParseSortFromString(sort) {
-			searchProvider.AddSort(sortField)
+	if err := apis.EnrichRecords(
+		&exportEchoContext{export: export},
+		s.app.Dao(),
+		records,
+		relations...,
+	); err != nil {
+		return err
+	}
+
+	return s.generateExportExpandBackRelations(records, export, list.ToUniqueStringSlice(backRelations))
+}
+
+// generateExportFillRow fills row with the formatted, masked and rendered header values of the record,
+// the templates render the masked values and the errors of their functions fail the row.
+func (s *PocketExport) generateExportFillRow(
+	row []any,
+	record *models.Record,
+	export *Export,
+	headers []HeaderItem,
+	headerSplitMap map[string][]string,
+	masks []Mask,
+) error {
+	var templateRecord map[string]any
+	for i := range headers {
+		item := &(headers)[i]
+		masked := false
+		if expression, _ := item.ParsedExpression(); expression != nil {
+			row[i] = s.generateExportGetExpressionValue(record, item, expression)
+		} else {
+			row[i], masked = s.generateExportGetRecordValue(record, item, headerSplitMap[item.FieldName], masks[i])
+		}
+
+		if masks[i] != nil && !masked {
+			row[i] = masks[i](row[i])
+		}
+
+		// the templates are validated with the export
+		if tmpl, _ := item.ParsedTemplate(); tmpl != nil {
+			if templateRecord == nil {
+				templateRecord = s.exportTemplateRecord(export.AuthRecord(), record)
+			}
+
+			value, err := renderHeaderTemplate(tmpl, &HeaderTemplateData{Value: row[i], Record: templateRecord})
+			if err != nil {
+				return fmt.Errorf("header %s: %w", item.Header, err)
+			}
+			row[i] = value
+		}
+	}
+
+	return nil
+}
+
+// generateExportPreview generates the header labels and the first limit formatted rows.
+func (s *PocketExport) generateExportPreview(export *Export, limit int) (*ExportPreview, error) {
+	headers := export.Headers()
+	headerSplitMap := s.generateExportGetHeaderSplitMap(export.ExportCollection(), headers)
+	expands := s.generateExportGetExpands(export, headerSplitMap)
+	masks, err := s.exportHeaderMasks(export)
+	if err != nil {
+		return nil, err
+	}
+
+	searchProvider, err := s.generateExportSearchProvider(
+		export.GetString(FilterField),
+		export.GetString(SortField),
+		export,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*models.Record, 0, limit)
+	result, err := searchProvider.Page(1).PerPage(limit).Exec(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.generateExportEnrichRecords(records, export, expands); err != nil {
+		return nil, err
+	}
+
+	preview := &ExportPreview{
+		Headers:    make([]string, len(headers)),
+		Rows:       make([][]any, 0, len(records)),
+		TotalItems: result.TotalItems,
+	}
+
+	if export.GetString(UnwindField) != "" {
+		if preview.TotalItems, err = s.countExportUnwoundRows(export); err != nil {
+			return nil, err
 		}
 	}
 
-	// ensure that the user has access to the collection
-	if export.Admin() == nil && export.ExportCollection().ListRule != nil {
-		searchProvider.AddFilter(search.FilterData(*export.ExportCollection().ListRule))
+	for i := range headers {
+		preview.Headers[i] = headers[i].Header
 	}
 
-	_, err := searchProvider.Exec(records)
+	for _, record := range records {
+		if err := s.generateExportEachUnwoundRecord(record, export, func(record *models.Record) error {
+			row := make([]any, len(headers))
+			if err := s.generateExportFillRow(row, record, export, headers, headerSplitMap, masks); err != nil {
+				return err
+			}
+			preview.Rows = append(preview.Rows, row)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// the unwound records can have more rows than the limit
+	if len(preview.Rows) > limit {
+		preview.Rows = preview.Rows[:limit]
+	}
+
+	return preview, nil
+}
+
+// generateExportFlush flushes dst if it supports flushing, eg. http response.
+func (s *PocketExport) generateExportFlush(dst io.Writer) {
+	if f, ok := dst.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// generateExportEachPage fetches the export records page by page
+// and calls fn with the enriched records of each page.
+func (s *PocketExport) generateExportEachPage(
+	filter string,
+	sort string,
+	export *Export,
+	fn func(records []*models.Record) error,
+) error {
+	records := make([]*models.Record, 0, generateExportPerPage)
+	headerSplitMap := s.generateExportGetHeaderSplitMap(export.ExportCollection(), export.Headers())
+	expands := s.generateExportGetExpands(export, headerSplitMap)
+
+	for page := 1; ; page++ {
+		records = records[:0]
+		if err := s.generateExportOutputRecords(&records, filter, sort, export, page); err != nil {
+			return err
+		}
+
+		if err := s.generateExportEnrichRecords(records, export, expands); err != nil {
+			return err
+		}
+
+		if err := fn(records); err != nil {
+			return err
+		}
+
+		if len(records) < generateExportPerPage {
+			return nil
+		}
+	}
+}
+
+// generateExportEachRow calls fn with the formatted values of every export row
+// and afterPage, if not nil, after every page. The row slice is reused between calls.
+func (s *PocketExport) generateExportEachRow(
+	filter string,
+	sort string,
+	export *Export,
+	fn func(row []any) error,
+	afterPage func() error,
+) error {
+	headers := export.Headers()
+	headerSplitMap := s.generateExportGetHeaderSplitMap(export.ExportCollection(), headers)
+	row := make([]any, len(headers))
+	masks, err := s.exportHeaderMasks(export)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.generateExportEachPage(filter, sort, export, func(records []*models.Record) error {
+		for _, record := range records {
+			if err := s.generateExportEachUnwoundRecord(record, export, func(record *models.Record) error {
+				if err := s.generateExportFillRow(row, record, export, headers, headerSplitMap, masks); err != nil {
+					return err
+				}
+				return fn(row)
+			}); err != nil {
+				return err
+			}
+		}
+
+		if afterPage == nil {
+			return nil
+		}
+
+		return afterPage()
+	})
 }
 
 // generateExportCSVOutput generates the export csv output.
@@ -153,55 +521,31 @@ func (s *PocketExport) generateExportCSVOutput(
 			headerStr = append(headerStr, item.Header)
 		}
 
-		csvWriter.WriteAll([][]string{headerStr})
+		if err := csvWriter.Write(headerStr); err != nil {
+			return err
+		}
 	}
 
 	// Write records
-	{
-		records := make([]*models.Record, 0, 1000)
-		row := make([]string, len(headers))
-		page := 1
-		headerSplitMap := s.generateExportGetHeaderSplitMap(headers)
-		expands := s.generateExportGetExpandsFromHeaderSplitMap(headerSplitMap)
-
-		for {
-			if err := s.generateExportOutputRecords(&records, filter, sort, export, page); err != nil {
-				return err
-			}
-
-			if err := apis.EnrichRecords(
-				&exportEchoContext{export: export},
-				s.app.Dao(),
-				records,
-				expands...,
-			); err != nil {
-				return err
-			}
-
-			for _, record := range records {
-				for i := range headers {
-					item := &(headers)[i]
-					splitKey := headerSplitMap[item.FieldName]
-					value := s.generateExportGetRecordValue(record, item, splitKey)
-					row[i] = fmt.Sprintf("%v", value)
-				}
-
-				csvWriter.WriteAll([][]string{row})
-			}
-
-			records = records[:0]
-			page += 1
+	rowStr := make([]string, len(headers))
+	return s.generateExportEachRow(filter, sort, export, func(row []any) error {
+		for i := range row {
+			rowStr[i] = fmt.Sprintf("%v", row[i])
+		}
 
-			if len(records) < 1000 {
-				break
-			}
+		return csvWriter.Write(rowStr)
+	}, func() error {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
 		}
-	}
 
-	return nil
+		s.generateExportFlush(buffer)
+		return nil
+	})
 }
 
-// generateExportCSVOutput generates the export xlsx output.
+// generateExportXLSXOutput generates the export xlsx output.
 func (s *PocketExport) generateExportXLSXOutput(
 	buffer io.Writer,
 	filter string,
@@ -243,60 +587,95 @@ func (s *PocketExport) generateExportXLSXOutput(
 	}
 
 	// Write records
-	{
-		records := make([]*models.Record, 0, 1000)
-		row := make([]any, len(headers))
-		page := 1
-		headerSplitMap := s.generateExportGetHeaderSplitMap(headers)
-		expands := s.generateExportGetExpandsFromHeaderSplitMap(headerSplitMap)
-
-		for {
-			if err := s.generateExportOutputRecords(&records, filter, sort, export, page); err != nil {
-				return err
-			}
+	if err := s.generateExportEachRow(filter, sort, export, func(row []any) error {
+		cell, err := excelize.CoordinatesToCellName(1, xlsxRowIndex)
+		if err != nil {
+			return err
+		}
 
-			if err := apis.EnrichRecords(
-				&exportEchoContext{export: export},
-				s.app.Dao(),
-				records,
-				expands...,
-			); err != nil {
-				return err
-			}
+		if err := xlsxWriter.SetRow(cell, row); err != nil {
+			return err
+		}
 
-			for _, record := range records {
-				for i := range headers {
-					item := &(headers)[i]
-					splitKey := headerSplitMap[item.FieldName]
-					row[i] = s.generateExportGetRecordValue(record, item, splitKey)
-				}
+		xlsxRowIndex += 1
+		return nil
+	}, nil); err != nil {
+		return err
+	}
 
-				cell, err := excelize.CoordinatesToCellName(1, xlsxRowIndex)
-				if err != nil {
-					return err
-				}
+	if err := xlsxWriter.Flush(); err != nil {
+		return err
+	}
 
-				if err := xlsxWriter.SetRow(cell, row); err != nil {
-					return err
-				}
+	return f.Write(buffer)
+}
 
-				xlsxRowIndex += 1
-			}
+// generateExportJSONOutput generates the export json output,
+// an array of objects keyed by the headers in order.
+func (s *PocketExport) generateExportJSONOutput(
+	buffer io.Writer,
+	filter string,
+	sort string,
+	export *Export,
+) error {
+	headers := export.Headers()
+	writer := bufio.NewWriter(buffer)
+
+	// encode the header keys once
+	keys := make([][]byte, len(headers))
+	for i := range headers {
+		key, err := json.Marshal(headers[i].Header)
+		if err != nil {
+			return err
+		}
+
+		keys[i] = key
+	}
 
-			records = records[:0]
-			page += 1
+	if err := writer.WriteByte('['); err != nil {
+		return err
+	}
+
+	// Write records
+	first := true
+	if err := s.generateExportEachRow(filter, sort, export, func(row []any) error {
+		if !first {
+			writer.WriteByte(',')
+		}
+		first = false
+
+		writer.WriteByte('{')
+		for i := range row {
+			value, err := json.Marshal(row[i])
+			if err != nil {
+				return err
+			}
 
-			if len(records) < 1000 {
-				break
+			if i > 0 {
+				writer.WriteByte(',')
 			}
+			writer.Write(keys[i])
+			writer.WriteByte(':')
+			writer.Write(value)
 		}
+
+		return writer.WriteByte('}')
+	}, func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		s.generateExportFlush(buffer)
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	if err := xlsxWriter.Flush(); err != nil {
+	if err := writer.WriteByte(']'); err != nil {
 		return err
 	}
 
-	return f.Write(buffer)
+	return writer.Flush()
 }
 
 // fake echo context for generating export output
@@ -0,0 +1,170 @@
+package pocketexport
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+var errInvalidJSONPath = validation.NewError(
+	"validation_invalid_json_path",
+	"invalid json path {{.path}}, expected a json field followed by keys, [index] or [*]",
+)
+
+// jsonPathToken is a step of a json path, an object key, an array index or an array wildcard
+type jsonPathToken struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// jsonPathFieldName returns the field name of a path segment without its indexes, eg. items for items[0]
+func jsonPathFieldName(segment string) string {
+	name, _, _ := strings.Cut(segment, "[")
+	return name
+}
+
+// isJSONField checks whether the path segment is a json field of the collection
+func isJSONField(collection *models.Collection, segment string) bool {
+	field := collection.Schema.GetFieldByName(jsonPathFieldName(segment))
+	return field != nil && field.Type == schema.FieldTypeJson
+}
+
+// parseJSONPath parses the json path of the segments starting with the json field,
+// eg. [meta items[0] sku] or [meta items[*] sku], the negative indexes count from the end
+func parseJSONPath(segments []string) ([]jsonPathToken, error) {
+	errInvalid := errInvalidJSONPath.SetParams(map[string]any{"path": strings.Join(segments, ".")})
+	tokens := []jsonPathToken{}
+
+	for i, segment := range segments {
+		name, rest, _ := strings.Cut(segment, "[")
+		if name == "" {
+			return nil, errInvalid
+		}
+
+		// the first segment is the json field
+		if i > 0 {
+			tokens = append(tokens, jsonPathToken{key: name})
+		}
+
+		for rest != "" {
+			index, next, ok := strings.Cut(rest, "]")
+			if !ok || (next != "" && next[0] != '[') {
+				return nil, errInvalid
+			}
+			rest = strings.TrimPrefix(next, "[")
+
+			if index == "*" {
+				tokens = append(tokens, jsonPathToken{wildcard: true})
+				continue
+			}
+
+			n, err := strconv.Atoi(index)
+			if err != nil {
+				return nil, errInvalid
+			}
+			tokens = append(tokens, jsonPathToken{index: n, isIndex: true})
+		}
+	}
+
+	return tokens, nil
+}
+
+// evalJSONPath returns the values of the json path, the missing keys and indexes are skipped
+// and multi is true if the path has a wildcard
+func evalJSONPath(root any, tokens []jsonPathToken) (values []any, multi bool) {
+	values = []any{root}
+
+	for _, token := range tokens {
+		next := make([]any, 0, len(values))
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]any:
+				if item, ok := v[token.key]; ok && !token.isIndex && !token.wildcard {
+					next = append(next, item)
+				}
+			case []any:
+				switch {
+				case token.wildcard:
+					next = append(next, v...)
+				case token.isIndex:
+					index := token.index
+					if index < 0 {
+						index += len(v)
+					}
+					if index >= 0 && index < len(v) {
+						next = append(next, v[index])
+					}
+				}
+			}
+		}
+
+		multi = multi || token.wildcard
+		values = next
+	}
+
+	return values, multi
+}
+
+// exportJSONPathValues returns the values of the json path of the record, the segments start with
+// the json field, the objects and arrays are exported as json and the null values are skipped
+func exportJSONPathValues(record *models.Record, segments []string) ([]any, bool) {
+	// the json paths are validated with the export
+	tokens, _ := parseJSONPath(segments)
+
+	var root any
+	if err := record.UnmarshalJSONField(jsonPathFieldName(segments[0]), &root); err != nil {
+		return nil, false
+	}
+
+	found, multi := evalJSONPath(root, tokens)
+	values := make([]any, 0, len(found))
+	for _, value := range found {
+		switch value.(type) {
+		case nil:
+			continue
+		case map[string]any, []any:
+			raw, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			value = types.JsonRaw(raw)
+		}
+		values = append(values, value)
+	}
+
+	return values, multi
+}
+
+// exportJSONPath returns the segments of the json path of the field path, starting with the json field,
+// eg. [meta items[0] sku] for the author.meta.items[0].sku path of messages. It returns nil if the path
+// is not a json path or not a valid field path, the invalid field paths are reported by the field resolver
+func exportJSONPath(dao *daos.Dao, collection *models.Collection, fieldName string) ([]string, error) {
+	collections, name, err := exportFieldCollections(dao, collection, fieldName)
+	if err != nil {
+		return nil, nil
+	}
+
+	splitKey := strings.Split(backRelationFieldPath(collection, fieldName), ".")
+	segments := splitKey[len(collections)-1:]
+	if len(segments) == 1 && !strings.Contains(segments[0], "[") {
+		return nil, nil
+	}
+
+	if !isJSONField(collections[len(collections)-1], name) {
+		return nil, errInvalidJSONPath.SetParams(map[string]any{"path": fieldName})
+	}
+
+	if _, err := parseJSONPath(segments); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
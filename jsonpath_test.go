@@ -0,0 +1,146 @@
+package pocketexport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func Test_evalJSONPath(t *testing.T) {
+	root := map[string]any{
+		"address": map[string]any{"city": "Hà Nội"},
+		"items":   []any{map[string]any{"sku": "A1"}, map[string]any{"sku": "B2"}},
+		"matrix":  []any{[]any{1.0, 2.0}, []any{3.0}},
+	}
+
+	scenarios := []struct {
+		path   string
+		expect string
+		multi  bool
+	}{
+		{"meta.address.city", "[Hà Nội]", false},
+		{"meta.items[0].sku", "[A1]", false},
+		{"meta.items[-1].sku", "[B2]", false},
+		{"meta.items[2].sku", "[]", false},
+		{"meta.items[*].sku", "[A1 B2]", true},
+		{"meta.matrix[*][0]", "[1 3]", true},
+		{"meta.address[0]", "[]", false},
+		{"meta.unknown.city", "[]", false},
+	}
+
+	for i, s := range scenarios {
+		tokens, err := parseJSONPath(strings.Split(s.path, "."))
+		if err != nil {
+			t.Fatalf("(%d) %v", i, err)
+		}
+
+		values, multi := evalJSONPath(root, tokens)
+		if v := fmt.Sprintf("%v", values); v != s.expect || multi != s.multi {
+			t.Fatalf("(%d) expect %s %v, got %s %v", i, s.expect, s.multi, v, multi)
+		}
+	}
+
+	for i, s := range []string{"meta.items[0", "meta.items[x]", "meta.items[0]sku", "meta..sku", "meta.[0]"} {
+		if _, err := parseJSONPath(strings.Split(s, ".")); err == nil {
+			t.Fatalf("(%d) expect error for %q", i, s)
+		}
+	}
+}
+
+func Test_pocketExport_JSONPathHeaders(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	dao := testApp.Dao()
+	for name, field := range map[string]string{"messages": "meta", "users": "settings"} {
+		collection, err := dao.FindCollectionByNameOrId(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collection.Schema.AddField(&schema.SchemaField{Name: field, Type: schema.FieldTypeJson, Options: &schema.JsonOptions{}})
+		if err := dao.SaveCollection(collection); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	message, err := dao.FindFirstRecordByData("messages", "message", "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Set("meta", map[string]any{
+		"address": map[string]any{"city": "Hà Nội"},
+		"items":   []any{map[string]any{"sku": "A1", "qty": 2}, map[string]any{"sku": "B2", "qty": 3}},
+	})
+	if err := dao.SaveRecord(message); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := dao.FindRecordById("users", "vzz4enej24xtni9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Set("settings", map[string]any{"lang": "vi"})
+	if err := dao.SaveRecord(user); err != nil {
+		t.Fatal(err)
+	}
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "meta.address.city", "header": "thành phố"},
+		map[string]any{"fieldName": "meta.address", "header": "địa chỉ"},
+		map[string]any{"fieldName": "meta.items[0].sku", "header": "đầu"},
+		map[string]any{"fieldName": "meta.items[-1].sku", "header": "cuối"},
+		map[string]any{"fieldName": "meta.items[*].sku", "header": "mã"},
+		map[string]any{"fieldName": "meta.items[*].qty", "header": "số lượng", "aggregate": "sum"},
+		map[string]any{"fieldName": "author.settings.lang", "header": "ngôn ngữ"},
+		map[string]any{"expression": "upper(meta.address.city)", "header": "hoa"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "thành phố,địa chỉ,đầu,cuối,mã,số lượng,ngôn ngữ,hoa\n" +
+		"Hà Nội,\"{\"\"city\"\":\"\"Hà Nội\"\"}\",A1,B2,\"A1, B2\",5,vi,HÀ NỘI\n" +
+		",,,,,0,,\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	scenarios := []struct {
+		fieldName string
+		code      string
+	}{
+		{"meta.items[x]", "validation_invalid_json_path"},
+		{"message[0]", "validation_invalid_json_path"},
+		{"author.name[0]", "validation_invalid_json_path"},
+		{"meta.address.city", "validation_field_not_allowed"},
+	}
+
+	CollectionFieldPolicy("messages", "users", FieldPolicy{Deny: []string{"meta"}})(&exportService.config)
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		record.Set(HeadersField, []any{map[string]any{"fieldName": s.fieldName, "header": "x"}})
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[HeadersField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+}
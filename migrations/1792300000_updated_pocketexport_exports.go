@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add json format
+		format := collection.Schema.GetFieldByName("format")
+		format.Options.(*schema.SelectOptions).Values = []string{"csv", "xlsx", "json"}
+
+		output := collection.Schema.GetFieldByName("output")
+		output.Options.(*schema.FileOptions).MimeTypes = []string{
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			"text/csv",
+			"application/json",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		format := collection.Schema.GetFieldByName("format")
+		format.Options.(*schema.SelectOptions).Values = []string{"csv", "xlsx"}
+
+		output := collection.Schema.GetFieldByName("output")
+		output.Options.(*schema.FileOptions).MimeTypes = []string{
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			"text/csv",
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
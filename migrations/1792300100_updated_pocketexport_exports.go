@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add rowCount
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "q0wqzcwy",
+			Name:     "rowCount",
+			Type:     schema.FieldTypeNumber,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.NumberOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove rowCount
+		collection.Schema.RemoveField("q0wqzcwy")
+
+		return dao.SaveCollection(collection)
+	})
+}
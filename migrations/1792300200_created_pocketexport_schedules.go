@@ -0,0 +1,245 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		jsonData := `{
+      "id": "auva42gw54lhpv4",
+      "created": "2026-10-18 08:00:00.000Z",
+      "updated": "2026-10-18 08:00:00.000Z",
+      "name": "pocketexport_schedules",
+      "type": "base",
+      "system": false,
+      "schema": [
+        {
+          "system": false,
+          "id": "8sbnqdzy",
+          "name": "exportCollectionName",
+          "type": "text",
+          "required": true,
+          "unique": false,
+          "options": {
+            "min": 1,
+            "max": 256,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "if3a7pbe",
+          "name": "headers",
+          "type": "json",
+          "required": true,
+          "unique": false,
+          "options": {}
+        },
+        {
+          "system": false,
+          "id": "k7zw8ezb",
+          "name": "filter",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "pr1gr9h5",
+          "name": "sort",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "tittq6fi",
+          "name": "format",
+          "type": "select",
+          "required": true,
+          "unique": false,
+          "options": {
+            "maxSelect": 1,
+            "values": [
+              "csv",
+              "xlsx",
+              "json"
+            ]
+          }
+        },
+        {
+          "system": false,
+          "id": "puhb06tl",
+          "name": "ownerId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "7l4v62v7",
+          "name": "ownerCollectionName",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "vebg45q4",
+          "name": "cron",
+          "type": "text",
+          "required": true,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": 256,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "qqkll6s0",
+          "name": "timezone",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": 256,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "on4x1t6y",
+          "name": "catchUp",
+          "type": "select",
+          "required": false,
+          "unique": false,
+          "options": {
+            "maxSelect": 1,
+            "values": [
+              "once",
+              "skip"
+            ]
+          }
+        },
+        {
+          "system": false,
+          "id": "yt4xt7ik",
+          "name": "retention",
+          "type": "number",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": 0,
+            "max": null
+          }
+        },
+        {
+          "system": false,
+          "id": "s7ubmhv9",
+          "name": "lastRunAt",
+          "type": "date",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": "",
+            "max": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "c9qz8c2a",
+          "name": "nextRunAt",
+          "type": "date",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": "",
+            "max": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "j5xq27ii",
+          "name": "lastExportId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "y7evbm04",
+          "name": "lastError",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        }
+      ],
+      "indexes": [
+        "CREATE INDEX ` + "`" + `idx_Wq3UH8w` + "`" + ` ON ` + "`" + `pocketexport_schedules` + "`" + ` (\n  ` + "`" + `ownerId` + "`" + `,\n  ` + "`" + `ownerCollectionName` + "`" + `\n)",
+        "CREATE INDEX ` + "`" + `idx_kQ2n7Xb` + "`" + ` ON ` + "`" + `pocketexport_schedules` + "`" + ` (` + "`" + `nextRunAt` + "`" + `)"
+      ],
+      "listRule": "ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName",
+      "viewRule": "ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName",
+      "createRule": "ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName",
+      "updateRule": "ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName && @request.data.ownerId:isset = false && @request.data.ownerCollectionName:isset = false",
+      "deleteRule": "ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName",
+      "options": {}
+    }`
+
+		collection := &models.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return daos.New(db).SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+		if err = dao.Delete(collection); err != nil {
+			return err
+		}
+		return dao.DeleteTable(collection.Name)
+	})
+}
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add status
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "m3kx0z6d",
+			Name:     "status",
+			Type:     schema.FieldTypeSelect,
+			Required: false,
+			Unique:   false,
+			Options: &schema.SelectOptions{
+				MaxSelect: 1,
+				Values:    []string{"pending", "success", "failed"},
+			},
+		})
+
+		// add error
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "w1c8rj5n",
+			Name:     "error",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add notifyEmail
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "e9hd2qpa",
+			Name:     "notifyEmail",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove status
+		collection.Schema.RemoveField("m3kx0z6d")
+
+		// remove error
+		collection.Schema.RemoveField("w1c8rj5n")
+
+		// remove notifyEmail
+		collection.Schema.RemoveField("e9hd2qpa")
+
+		return dao.SaveCollection(collection)
+	})
+}
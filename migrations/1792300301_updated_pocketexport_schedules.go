@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// add notifyEmail
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "u4bq7v2s",
+			Name:     "notifyEmail",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// remove notifyEmail
+		collection.Schema.RemoveField("u4bq7v2s")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add webhookUrl
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "k7wh2nqx",
+			Name:     "webhookUrl",
+			Type:     schema.FieldTypeUrl,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.UrlOptions{},
+		})
+
+		// add webhookAttempts
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "b5ta9mre",
+			Name:     "webhookAttempts",
+			Type:     schema.FieldTypeNumber,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.NumberOptions{},
+		})
+
+		// add webhookError
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "r2ye6lvd",
+			Name:     "webhookError",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add webhookNextRetryAt
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "h8nc3zpo",
+			Name:     "webhookNextRetryAt",
+			Type:     schema.FieldTypeDate,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.DateOptions{},
+		})
+
+		// add webhookDeliveredAt
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "f4gu1sjk",
+			Name:     "webhookDeliveredAt",
+			Type:     schema.FieldTypeDate,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.DateOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove webhookUrl
+		collection.Schema.RemoveField("k7wh2nqx")
+
+		// remove webhookAttempts
+		collection.Schema.RemoveField("b5ta9mre")
+
+		// remove webhookError
+		collection.Schema.RemoveField("r2ye6lvd")
+
+		// remove webhookNextRetryAt
+		collection.Schema.RemoveField("h8nc3zpo")
+
+		// remove webhookDeliveredAt
+		collection.Schema.RemoveField("f4gu1sjk")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// add webhookUrl
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "x3vd8kwe",
+			Name:     "webhookUrl",
+			Type:     schema.FieldTypeUrl,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.UrlOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// remove webhookUrl
+		collection.Schema.RemoveField("x3vd8kwe")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add destination
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "d6pm4xte",
+			Name:     "destination",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add destinationPath
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "n9qs5wyb",
+			Name:     "destinationPath",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add destinationKey
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "j1rk7cmu",
+			Name:     "destinationKey",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove destination
+		collection.Schema.RemoveField("d6pm4xte")
+
+		// remove destinationPath
+		collection.Schema.RemoveField("n9qs5wyb")
+
+		// remove destinationKey
+		collection.Schema.RemoveField("j1rk7cmu")
+
+		return dao.SaveCollection(collection)
+	})
+}
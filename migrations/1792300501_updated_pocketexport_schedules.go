@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// add destination
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "g2zt8hfa",
+			Name:     "destination",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add destinationPath
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "p5lv3ndq",
+			Name:     "destinationPath",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// remove destination
+		collection.Schema.RemoveField("g2zt8hfa")
+
+		// remove destinationPath
+		collection.Schema.RemoveField("p5lv3ndq")
+
+		return dao.SaveCollection(collection)
+	})
+}
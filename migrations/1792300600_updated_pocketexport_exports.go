@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// protect output, it is downloaded with file tokens or signed links
+		output := collection.Schema.GetFieldByName("output")
+		output.Options.(*schema.FileOptions).Protected = true
+
+		// add downloadCount
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "s8ow2kzv",
+			Name:     "downloadCount",
+			Type:     schema.FieldTypeNumber,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.NumberOptions{},
+		})
+
+		// add lastDownloadedAt
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "c3bi9yxl",
+			Name:     "lastDownloadedAt",
+			Type:     schema.FieldTypeDate,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.DateOptions{},
+		})
+
+		// add downloadNonces
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "t6fa1uqg",
+			Name:     "downloadNonces",
+			Type:     schema.FieldTypeJson,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.JsonOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		output := collection.Schema.GetFieldByName("output")
+		output.Options.(*schema.FileOptions).Protected = false
+
+		// remove downloadCount
+		collection.Schema.RemoveField("s8ow2kzv")
+
+		// remove lastDownloadedAt
+		collection.Schema.RemoveField("c3bi9yxl")
+
+		// remove downloadNonces
+		collection.Schema.RemoveField("t6fa1uqg")
+
+		return dao.SaveCollection(collection)
+	})
+}
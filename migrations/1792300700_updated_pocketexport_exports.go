@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// owners can pin their exports, the other fields are read only
+		collection.UpdateRule = types.Pointer("ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName")
+
+		// add pinned
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "o7ej5rbw",
+			Name:     "pinned",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		// add outputSize
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "y4hn8vcs",
+			Name:     "outputSize",
+			Type:     schema.FieldTypeNumber,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.NumberOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		collection.UpdateRule = nil
+
+		// remove pinned
+		collection.Schema.RemoveField("o7ej5rbw")
+
+		// remove outputSize
+		collection.Schema.RemoveField("y4hn8vcs")
+
+		return dao.SaveCollection(collection)
+	})
+}
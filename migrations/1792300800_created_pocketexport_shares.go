@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		jsonData := `{
+      "id": "yw5k8qfg3nowjil",
+      "created": "2026-10-18 09:00:00.000Z",
+      "updated": "2026-10-18 09:00:00.000Z",
+      "name": "pocketexport_shares",
+      "type": "base",
+      "system": false,
+      "schema": [
+        {
+          "system": false,
+          "id": "vdbsfa6l",
+          "name": "export",
+          "type": "relation",
+          "required": true,
+          "unique": false,
+          "options": {
+            "collectionId": "utge0b58a4971cg",
+            "cascadeDelete": true,
+            "minSelect": null,
+            "maxSelect": 1,
+            "displayFields": []
+          }
+        },
+        {
+          "system": false,
+          "id": "pr1x9r8e",
+          "name": "recipientId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "05ywzpzk",
+          "name": "recipientCollectionName",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "h65n7sl5",
+          "name": "public",
+          "type": "bool",
+          "required": false,
+          "unique": false,
+          "options": {}
+        },
+        {
+          "system": false,
+          "id": "u9kay5in",
+          "name": "token",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "0xn5qcp6",
+          "name": "expiresAt",
+          "type": "date",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": "",
+            "max": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "36s2fptn",
+          "name": "revoked",
+          "type": "bool",
+          "required": false,
+          "unique": false,
+          "options": {}
+        }
+      ],
+      "indexes": [
+        "CREATE INDEX ` + "`" + `idx_IOfE5Yr` + "`" + ` ON ` + "`" + `pocketexport_shares` + "`" + ` (` + "`" + `export` + "`" + `)",
+        "CREATE INDEX ` + "`" + `idx_p3Rk9Ta` + "`" + ` ON ` + "`" + `pocketexport_shares` + "`" + ` (` + "`" + `token` + "`" + `)"
+      ],
+      "listRule": "export.ownerId = @request.auth.id && export.ownerCollectionName = @request.auth.collectionName",
+      "viewRule": "export.ownerId = @request.auth.id && export.ownerCollectionName = @request.auth.collectionName",
+      "createRule": "export.ownerId = @request.auth.id && export.ownerCollectionName = @request.auth.collectionName",
+      "updateRule": "export.ownerId = @request.auth.id && export.ownerCollectionName = @request.auth.collectionName",
+      "deleteRule": "export.ownerId = @request.auth.id && export.ownerCollectionName = @request.auth.collectionName",
+      "options": {}
+    }`
+
+		collection := &models.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return daos.New(db).SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+		collection, err := dao.FindCollectionByNameOrId("yw5k8qfg3nowjil")
+		if err != nil {
+			return err
+		}
+		if err = dao.Delete(collection); err != nil {
+			return err
+		}
+		return dao.DeleteTable(collection.Name)
+	})
+}
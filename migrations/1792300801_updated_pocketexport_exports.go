@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// the recipients of an active, non public share can read the export
+		rule := "(ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName) || " +
+			"(@request.auth.id != \"\" && " +
+			"@collection.pocketexport_shares.export ?= id && " +
+			"@collection.pocketexport_shares.revoked ?= false && " +
+			"@collection.pocketexport_shares.public ?= false && " +
+			"@collection.pocketexport_shares.recipientCollectionName ?= @request.auth.collectionName && " +
+			"(@collection.pocketexport_shares.recipientId ?= \"\" || @collection.pocketexport_shares.recipientId ?= @request.auth.id) && " +
+			"(@collection.pocketexport_shares.expiresAt ?= \"\" || @collection.pocketexport_shares.expiresAt ?> @now))"
+		collection.ListRule = types.Pointer(rule)
+		collection.ViewRule = types.Pointer(rule)
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		collection.ListRule = types.Pointer("ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName")
+		collection.ViewRule = types.Pointer("ownerId = @request.auth.id && ownerCollectionName = @request.auth.collectionName")
+
+		return dao.SaveCollection(collection)
+	})
+}
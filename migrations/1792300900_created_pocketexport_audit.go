@@ -0,0 +1,209 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		jsonData := `{
+      "id": "mtar6bq2ovwnxhx",
+      "created": "2026-10-18 10:00:00.000Z",
+      "updated": "2026-10-18 10:00:00.000Z",
+      "name": "pocketexport_audit",
+      "type": "base",
+      "system": false,
+      "schema": [
+        {
+          "system": false,
+          "id": "f6cx27g3",
+          "name": "action",
+          "type": "select",
+          "required": true,
+          "unique": false,
+          "options": {
+            "maxSelect": 1,
+            "values": [
+              "create",
+              "generate",
+              "download",
+              "stream",
+              "share",
+              "delete"
+            ]
+          }
+        },
+        {
+          "system": false,
+          "id": "sy12jdf0",
+          "name": "exportId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "e8sx1xc7",
+          "name": "shareId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "n60eaa6x",
+          "name": "actorId",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "eobuw7kb",
+          "name": "actorCollectionName",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "anto6tcs",
+          "name": "ip",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "rn4jnrd2",
+          "name": "userAgent",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "2g9arpf2",
+          "name": "exportCollectionName",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "ivwbgjek",
+          "name": "filter",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "6p7s4bl8",
+          "name": "headers",
+          "type": "json",
+          "required": false,
+          "unique": false,
+          "options": {}
+        },
+        {
+          "system": false,
+          "id": "sinhk178",
+          "name": "rowCount",
+          "type": "number",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null
+          }
+        },
+        {
+          "system": false,
+          "id": "awredokz",
+          "name": "checksum",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        }
+      ],
+      "indexes": [
+        "CREATE INDEX ` + "`" + `idx_F9e6CMN` + "`" + ` ON ` + "`" + `pocketexport_audit` + "`" + ` (` + "`" + `exportId` + "`" + `)",
+        "CREATE INDEX ` + "`" + `idx_JkPYKTf` + "`" + ` ON ` + "`" + `pocketexport_audit` + "`" + ` (\n  ` + "`" + `actorId` + "`" + `,\n  ` + "`" + `actorCollectionName` + "`" + `\n)"
+      ],
+      "listRule": null,
+      "viewRule": null,
+      "createRule": null,
+      "updateRule": null,
+      "deleteRule": null,
+      "options": {}
+    }`
+
+		collection := &models.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return daos.New(db).SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+		collection, err := dao.FindCollectionByNameOrId("mtar6bq2ovwnxhx")
+		if err != nil {
+			return err
+		}
+		if err = dao.Delete(collection); err != nil {
+			return err
+		}
+		return dao.DeleteTable(collection.Name)
+	})
+}
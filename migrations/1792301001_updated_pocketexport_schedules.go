@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// the headers default to the collection config headers
+		if field := collection.Schema.GetFieldById("if3a7pbe"); field != nil {
+			field.Required = false
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		if field := collection.Schema.GetFieldById("if3a7pbe"); field != nil {
+			field.Required = true
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
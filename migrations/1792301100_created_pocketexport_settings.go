@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		jsonData := `{
+      "id": "ex4i9bhgjldruob",
+      "created": "2026-10-18 11:00:00.000Z",
+      "updated": "2026-10-18 11:00:00.000Z",
+      "name": "pocketexport_settings",
+      "type": "base",
+      "system": false,
+      "schema": [
+        {
+          "system": false,
+          "id": "yvokys9r",
+          "name": "collectionName",
+          "type": "text",
+          "required": true,
+          "unique": false,
+          "options": {
+            "min": 1,
+            "max": 256,
+            "pattern": ""
+          }
+        },
+        {
+          "system": false,
+          "id": "yapb2jju",
+          "name": "exportRule",
+          "type": "text",
+          "required": false,
+          "unique": false,
+          "options": {
+            "min": null,
+            "max": null,
+            "pattern": ""
+          }
+        }
+      ],
+      "indexes": [
+        "CREATE UNIQUE INDEX ` + "`" + `idx_MKlB4Y2` + "`" + ` ON ` + "`" + `pocketexport_settings` + "`" + ` (` + "`" + `collectionName` + "`" + `)"
+      ],
+      "listRule": null,
+      "viewRule": null,
+      "createRule": null,
+      "updateRule": null,
+      "deleteRule": null,
+      "options": {}
+    }`
+
+		collection := &models.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return daos.New(db).SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+		collection, err := dao.FindCollectionByNameOrId("ex4i9bhgjldruob")
+		if err != nil {
+			return err
+		}
+		if err = dao.Delete(collection); err != nil {
+			return err
+		}
+		return dao.DeleteTable(collection.Name)
+	})
+}
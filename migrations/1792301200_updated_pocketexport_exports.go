@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add fingerprint
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "qk3vwz7h",
+			Name:     "fingerprint",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add duplicateOf
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "m8rjd2xp",
+			Name:     "duplicateOf",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove fingerprint
+		collection.Schema.RemoveField("qk3vwz7h")
+
+		// remove duplicateOf
+		collection.Schema.RemoveField("m8rjd2xp")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add timezone
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "w4ncx9tb",
+			Name:     "timezone",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add locale
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "z7lq2hrd",
+			Name:     "locale",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove timezone
+		collection.Schema.RemoveField("w4ncx9tb")
+
+		// remove locale
+		collection.Schema.RemoveField("z7lq2hrd")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// add unwind
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "h3uw8nyc",
+			Name:     "unwind",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add unwindKeepEmpty
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "r6fk1jxe",
+			Name:     "unwindKeepEmpty",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("utge0b58a4971cg")
+		if err != nil {
+			return err
+		}
+
+		// remove unwind
+		collection.Schema.RemoveField("h3uw8nyc")
+
+		// remove unwindKeepEmpty
+		collection.Schema.RemoveField("r6fk1jxe")
+
+		return dao.SaveCollection(collection)
+	})
+}
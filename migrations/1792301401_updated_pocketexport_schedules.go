@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// add unwind
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "p2dv9sle",
+			Name:     "unwind",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// add unwindKeepEmpty
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "c8mz4toa",
+			Name:     "unwindKeepEmpty",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("auva42gw54lhpv4")
+		if err != nil {
+			return err
+		}
+
+		// remove unwind
+		collection.Schema.RemoveField("p2dv9sle")
+
+		// remove unwindKeepEmpty
+		collection.Schema.RemoveField("c8mz4toa")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ex4i9bhgjldruob")
+		if err != nil {
+			return err
+		}
+
+		// add adminOnly, locks the export to the admins whatever the list rule
+		collection.Schema.AddField(&schema.SchemaField{
+			System:   false,
+			Id:       "q3wz7kdn",
+			Name:     "adminOnly",
+			Type:     schema.FieldTypeBool,
+			Required: false,
+			Unique:   false,
+			Options:  &schema.BoolOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ex4i9bhgjldruob")
+		if err != nil {
+			return err
+		}
+
+		// remove adminOnly
+		collection.Schema.RemoveField("q3wz7kdn")
+
+		return dao.SaveCollection(collection)
+	})
+}
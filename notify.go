@@ -0,0 +1,209 @@
+package pocketexport
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	"net/mail"
+	"text/template"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+const (
+	defaultNotifyEmailSuccessSubject = `{{.AppName}} - your {{.ExportCollectionName}} export is ready`
+	defaultNotifyEmailSuccessBody    = `<p>Hello,</p>
+<p>Your <strong>{{.ExportCollectionName}}</strong> export is ready.</p>
+{{if .Attached}}<p>The file is attached to this email.</p>{{else if .Link}}<p><a href="{{.Link}}" target="_blank" rel="noopener">Download the export</a>, the link expires at {{.LinkExpires.Format "2006-01-02 15:04:05 MST"}}.</p>{{end}}
+<p>Thanks,<br/>{{.AppName}} team</p>`
+	defaultNotifyEmailFailureSubject = `{{.AppName}} - your {{.ExportCollectionName}} export failed`
+	defaultNotifyEmailFailureBody    = `<p>Hello,</p>
+<p>Your <strong>{{.ExportCollectionName}}</strong> export failed: {{.Error}}</p>
+<p>Thanks,<br/>{{.AppName}} team</p>`
+)
+
+// NotifyEmailData is the data of the notification email templates
+type NotifyEmailData struct {
+	AppName              string
+	AppUrl               string
+	Export               *models.Record
+	ExportCollectionName string
+	Status               string
+	Error                string
+	// Link is the download link, empty when the output is attached or the export failed
+	Link        string
+	LinkExpires time.Time
+	Attached    bool
+	// DestinationKey is the path of the output in the export destination
+	DestinationKey string
+}
+
+type notifyEmailTemplates struct {
+	successSubject *template.Template
+	successBody    *htmltemplate.Template
+	failureSubject *template.Template
+	failureBody    *htmltemplate.Template
+}
+
+// newNotifyEmailTemplates parses the notification email templates of the config
+func newNotifyEmailTemplates(rc *registerConfig) (*notifyEmailTemplates, error) {
+	var err error
+	t := &notifyEmailTemplates{}
+
+	if t.successSubject, err = template.New("successSubject").Parse(rc.notifyEmailSuccessSubject); err != nil {
+		return nil, err
+	}
+
+	if t.successBody, err = htmltemplate.New("successBody").Parse(rc.notifyEmailSuccessBody); err != nil {
+		return nil, err
+	}
+
+	if t.failureSubject, err = template.New("failureSubject").Parse(rc.notifyEmailFailureSubject); err != nil {
+		return nil, err
+	}
+
+	if t.failureBody, err = htmltemplate.New("failureBody").Parse(rc.notifyEmailFailureBody); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// notifyExportEmailAddress returns the email address of the export owner
+func (p *PocketExport) notifyExportEmailAddress(export *Export) string {
+	if admin := export.Admin(); admin != nil {
+		return admin.Email
+	}
+
+	if authRecord := export.AuthRecord(); authRecord != nil {
+		return authRecord.Email()
+	}
+
+	return ""
+}
+
+// notifyExportEmail emails the export owner about the generation status
+// if the export opted in, the output is attached below the configured size
+// or linked with a time-limited download link.
+func (p *PocketExport) notifyExportEmail(record *models.Record) error {
+	if !record.GetBool(NotifyEmailField) {
+		return nil
+	}
+
+	templates := p.emailTemplates
+	if templates == nil {
+		var err error
+		if templates, err = newNotifyEmailTemplates(&p.config); err != nil {
+			return err
+		}
+	}
+
+	export := NewExport(record)
+	if err := export.Fill(p.app.Dao()); err != nil {
+		return err
+	}
+
+	address := p.notifyExportEmailAddress(export)
+	if address == "" {
+		return nil
+	}
+
+	settings := p.app.Settings()
+	data := &NotifyEmailData{
+		AppName:              settings.Meta.AppName,
+		AppUrl:               settings.Meta.AppUrl,
+		Export:               record,
+		ExportCollectionName: record.GetString(ExportCollectionNameField),
+		Status:               record.GetString(StatusField),
+		Error:                record.GetString(ErrorField),
+		DestinationKey:       record.GetString(DestinationKeyField),
+	}
+
+	message := &mailer.Message{
+		From: mail.Address{
+			Name:    settings.Meta.SenderName,
+			Address: settings.Meta.SenderAddress,
+		},
+		To: []mail.Address{{Address: address}},
+	}
+
+	subjectTemplate, bodyTemplate := templates.failureSubject, templates.failureBody
+	if data.Status != StatusFailed {
+		subjectTemplate, bodyTemplate = templates.successSubject, templates.successBody
+	}
+
+	// no link when the output is only kept by the destination
+	if data.Status != StatusFailed && record.GetString(OutputField) != "" {
+		attachment, err := p.notifyExportEmailAttachment(record)
+		if err != nil {
+			return err
+		}
+
+		if attachment != nil {
+			data.Attached = true
+			message.Attachments = map[string]io.Reader{record.GetString(OutputField): attachment}
+		} else {
+			token, err := p.newDownloadToken(record, p.config.notifyEmailLinkDuration, "")
+			if err != nil {
+				return err
+			}
+
+			data.Link = p.downloadURL(record, token)
+			data.LinkExpires = time.Now().Add(p.config.notifyEmailLinkDuration).UTC()
+		}
+	}
+
+	subject := new(bytes.Buffer)
+	if err := subjectTemplate.Execute(subject, data); err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	if err := bodyTemplate.Execute(body, data); err != nil {
+		return err
+	}
+
+	message.Subject = subject.String()
+	message.HTML = body.String()
+
+	return p.app.NewMailClient().Send(message)
+}
+
+// notifyExportEmailAttachment returns the export output if it is small enough
+// to be attached to the notification email, nil otherwise.
+func (p *PocketExport) notifyExportEmailAttachment(record *models.Record) (io.Reader, error) {
+	if p.config.notifyEmailAttachmentMax <= 0 {
+		return nil, nil
+	}
+
+	fs, err := p.app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+
+	fileKey := record.BaseFilesPath() + "/" + record.GetString(OutputField)
+	attrs, err := fs.Attributes(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if attrs.Size > p.config.notifyEmailAttachmentMax {
+		return nil, nil
+	}
+
+	r, err := fs.GetFile(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	content := new(bytes.Buffer)
+	if _, err := content.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
@@ -0,0 +1,89 @@
+package pocketexport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func saveNotifyExportRecord(t *testing.T, app *tests.TestApp) *models.Record {
+	record := getExportRecord(t, app)
+	record.Set(NotifyEmailField, true)
+	record.Set(StatusField, StatusPending)
+	record.Set(OutputField, exportOutputFilename(FormatCSV))
+	if err := app.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
+
+func Test_pocketExport_NotifyExportEmail(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	record := saveNotifyExportRecord(t, testApp)
+
+	// attached below the threshold
+	if err := exportService.processExport(record.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if testApp.TestMailer.TotalSend != 1 {
+		t.Fatalf("expect 1 email, got %d", testApp.TestMailer.TotalSend)
+	}
+
+	message := testApp.TestMailer.LastMessage
+	if len(message.To) != 1 || message.To[0].Address != "eddiebrock2001@gmail.com" {
+		t.Fatalf("wrong recipient %v", message.To)
+	} else if _, ok := message.Attachments[record.GetString(OutputField)]; !ok {
+		t.Fatal("should attach the output")
+	} else if !strings.Contains(message.Subject, "messages export is ready") {
+		t.Fatal(message.Subject)
+	}
+
+	// linked above the threshold
+	NotifyEmailAttachmentMaxSize(0)(&exportService.config)
+	NotifyEmailSuccessTemplate("done", `<a href="{{.Link}}">{{.ExportCollectionName}}</a>`)(&exportService.config)
+	if err := exportService.notifyExportEmail(record); err != nil {
+		t.Fatal(err)
+	}
+
+	message = testApp.TestMailer.LastMessage
+	if len(message.Attachments) != 0 {
+		t.Fatal("should not attach the output")
+	} else if message.Subject != "done" {
+		t.Fatal(message.Subject)
+	} else if !strings.Contains(message.HTML, "/api/pocketexport/download/"+record.Id+"?token=") {
+		t.Fatal(message.HTML)
+	}
+
+	// failed
+	record.Set(StatusField, StatusFailed)
+	record.Set(ErrorField, "boom")
+	if err := exportService.notifyExportEmail(record); err != nil {
+		t.Fatal(err)
+	}
+
+	message = testApp.TestMailer.LastMessage
+	if !strings.Contains(message.Subject, "messages export failed") {
+		t.Fatal(message.Subject)
+	} else if !strings.Contains(message.HTML, "boom") {
+		t.Fatal(message.HTML)
+	}
+
+	// not opted in
+	testApp.TestMailer.Reset()
+	record.Set(NotifyEmailField, false)
+	if err := exportService.notifyExportEmail(record); err != nil {
+		t.Fatal(err)
+	} else if testApp.TestMailer.TotalSend != 0 {
+		t.Fatal("should not send email")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/pocketbase/dbx"
@@ -15,6 +17,7 @@ import (
 	"github.com/pocketbase/pocketbase/tools/routine"
 	"github.com/pocketbase/pocketbase/tools/security"
 	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/text/message"
 )
 
 const (
@@ -22,8 +25,17 @@ const (
 	FormatCSV = "csv"
 	// FormatXLSX is the xlsx format
 	FormatXLSX = "xlsx"
+	// FormatJSON is the json format
+	FormatJSON = "json"
 )
 
+// formatContentTypes maps the export formats to their content types
+var formatContentTypes = map[string]string{
+	FormatCSV:  "text/csv",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatJSON: "application/json",
+}
+
 const (
 	// ExportCollectionField is the field name for the export collection
 	PocketExportCollectionName = "pocketexport_exports"
@@ -43,6 +55,180 @@ const (
 	FormatField = "format"
 	// OutputField is the field name for the export output
 	OutputField = "output"
+	// RowCountField is the field name for the number of exported rows
+	RowCountField = "rowCount"
+	// ScheduleIdField is the field name for the schedule that created the export
+	ScheduleIdField = "scheduleId"
+	// StatusField is the field name for the export generation status
+	StatusField = "status"
+	// ErrorField is the field name for the export generation error
+	ErrorField = "error"
+	// NotifyEmailField is the field name for the export email notification opt-in
+	NotifyEmailField = "notifyEmail"
+	// WebhookUrlField is the field name for the export webhook url
+	WebhookUrlField = "webhookUrl"
+	// WebhookAttemptsField is the field name for the number of webhook delivery attempts
+	WebhookAttemptsField = "webhookAttempts"
+	// WebhookErrorField is the field name for the last webhook delivery error
+	WebhookErrorField = "webhookError"
+	// WebhookNextRetryAtField is the field name for the next webhook delivery retry date
+	WebhookNextRetryAtField = "webhookNextRetryAt"
+	// WebhookDeliveredAtField is the field name for the webhook delivery date
+	WebhookDeliveredAtField = "webhookDeliveredAt"
+	// DestinationField is the field name for the export destination name
+	DestinationField = "destination"
+	// DestinationPathField is the field name for the export destination path template
+	DestinationPathField = "destinationPath"
+	// DestinationKeyField is the field name for the resolved export destination path
+	DestinationKeyField = "destinationKey"
+	// DownloadCountField is the field name for the number of downloads through signed links
+	DownloadCountField = "downloadCount"
+	// LastDownloadedAtField is the field name for the last download date through signed links
+	LastDownloadedAtField = "lastDownloadedAt"
+	// DownloadNoncesField is the field name for the unused one-time download link nonces
+	DownloadNoncesField = "downloadNonces"
+	// PinnedField is the field name for the export cleanup opt-out
+	PinnedField = "pinned"
+	// OutputSizeField is the field name for the export output size in bytes
+	OutputSizeField = "outputSize"
+	// ChecksumField is the field name for the hex encoded sha256 of the export output
+	ChecksumField = "checksum"
+	// FingerprintField is the field name for the hex encoded sha256 of the fields changing the export output
+	FingerprintField = "fingerprint"
+	// DuplicateOfField is the field name for the export whose output is reused
+	DuplicateOfField = "duplicateOf"
+	// UnwindField is the field name for the multi-valued field exported with a row per value
+	UnwindField = "unwind"
+	// UnwindKeepEmptyField is the field name for the unwind opt-in of the records without values
+	UnwindKeepEmptyField = "unwindKeepEmpty"
+)
+
+const (
+	// StatusPending is the status of an export generated in background
+	StatusPending = "pending"
+	// StatusSuccess is the status of a generated export
+	StatusSuccess = "success"
+	// StatusFailed is the status of an export that failed to generate
+	StatusFailed = "failed"
+)
+
+const (
+	// PocketExportScheduleCollectionName is the name of the schedules collection
+	PocketExportScheduleCollectionName = "pocketexport_schedules"
+	// CronField is the field name for the schedule cron expression
+	CronField = "cron"
+	// TimezoneField is the field name for the schedule cron timezone
+	TimezoneField = "timezone"
+	// DefaultTimezoneField is the field name for the default timezone of the export headers
+	DefaultTimezoneField = "defaultTimezone"
+	// LocaleField is the field name for the default locale of the export headers
+	LocaleField = "locale"
+	// CatchUpField is the field name for the schedule missed run policy
+	CatchUpField = "catchUp"
+	// RetentionField is the field name for the number of schedule exports to keep
+	RetentionField = "retention"
+	// LastRunAtField is the field name for the schedule last run date
+	LastRunAtField = "lastRunAt"
+	// NextRunAtField is the field name for the schedule next run date
+	NextRunAtField = "nextRunAt"
+	// LastExportIdField is the field name for the last export created by the schedule
+	LastExportIdField = "lastExportId"
+	// LastErrorField is the field name for the schedule last run error
+	LastErrorField = "lastError"
+)
+
+const (
+	// PocketExportShareCollectionName is the name of the shares collection
+	PocketExportShareCollectionName = "pocketexport_shares"
+	// ShareExportField is the field name for the shared export
+	ShareExportField = "export"
+	// RecipientIdField is the field name for the share recipient id, empty shares with the whole collection
+	RecipientIdField = "recipientId"
+	// RecipientCollectionNameField is the field name for the share recipient auth collection
+	RecipientCollectionNameField = "recipientCollectionName"
+	// PublicField is the field name for the public share opt-in
+	PublicField = "public"
+	// TokenField is the field name for the public share token
+	TokenField = "token"
+	// ExpiresAtField is the field name for the share expiration date
+	ExpiresAtField = "expiresAt"
+	// RevokedField is the field name for the share revocation
+	RevokedField = "revoked"
+)
+
+const (
+	// PocketExportSettingsCollectionName is the name of the collection export settings collection
+	PocketExportSettingsCollectionName = "pocketexport_settings"
+	// CollectionNameField is the field name for the name of the configured collection
+	CollectionNameField = "collectionName"
+	// ExportRuleField is the field name for the collection export rule, empty falls back to the list rule
+	ExportRuleField = "exportRule"
+	// AdminOnlyField is the field name for locking the collection export to the admins, whatever the list rule
+	AdminOnlyField = "adminOnly"
+)
+
+const (
+	// PocketExportAuditCollectionName is the name of the append-only audit collection
+	PocketExportAuditCollectionName = "pocketexport_audit"
+	// ActionField is the field name for the audited action
+	ActionField = "action"
+	// ExportIdField is the field name for the audited export id
+	ExportIdField = "exportId"
+	// ShareIdField is the field name for the audited share id
+	ShareIdField = "shareId"
+	// ActorIdField is the field name for the admin or auth record id that did the action
+	ActorIdField = "actorId"
+	// ActorCollectionNameField is the field name for the actor auth collection, empty for admins
+	ActorCollectionNameField = "actorCollectionName"
+	// IpField is the field name for the client ip of the action
+	IpField = "ip"
+	// UserAgentField is the field name for the client user agent of the action
+	UserAgentField = "userAgent"
+)
+
+const (
+	// AuditActionCreate is the audit action of a created export
+	AuditActionCreate = "create"
+	// AuditActionGenerate is the audit action of a generated export output
+	AuditActionGenerate = "generate"
+	// AuditActionDownload is the audit action of a downloaded export output
+	AuditActionDownload = "download"
+	// AuditActionStream is the audit action of an export streamed without a record
+	AuditActionStream = "stream"
+	// AuditActionShare is the audit action of a shared export
+	AuditActionShare = "share"
+	// AuditActionDelete is the audit action of a deleted export
+	AuditActionDelete = "delete"
+)
+
+const (
+	// CatchUpOnce runs a missed schedule once, it is the default
+	CatchUpOnce = "once"
+	// CatchUpSkip skips a missed schedule until its next run
+	CatchUpSkip = "skip"
+)
+
+const (
+	// AggregateJoin joins the values of a multi-valued header with its separator, it is the default
+	AggregateJoin = "join"
+	// AggregateCount counts the values of a multi-valued header
+	AggregateCount = "count"
+	// AggregateFirst exports the first value of a multi-valued header
+	AggregateFirst = "first"
+	// AggregateLast exports the last value of a multi-valued header
+	AggregateLast = "last"
+	// AggregateSum sums the numeric values of a multi-valued header
+	AggregateSum = "sum"
+	// AggregateMin exports the minimum numeric or date value of a multi-valued header
+	AggregateMin = "min"
+	// AggregateMax exports the maximum numeric or date value of a multi-valued header
+	AggregateMax = "max"
+	// AggregateAvg averages the numeric values of a multi-valued header
+	AggregateAvg = "avg"
+	// AggregateJSON exports the values of a multi-valued header as an array, only for json outputs
+	AggregateJSON = "json"
+	// AggregateExists exports whether a multi-valued header has values
+	AggregateExists = "exists"
 )
 
 type RegisterOption func(*registerConfig)
@@ -51,12 +237,73 @@ type registerConfig struct {
 	generateOutputInBackground bool
 	autoDelete                 bool
 	autoDeleteDuration         time.Duration
+	collectionRetention        map[string]time.Duration
+	authCollectionRetention    map[string]time.Duration
+	maxExportsPerOwner         int
+	ownerStorageQuota          int64
+	dedupeWindow               time.Duration
+	ownerRateLimit             RateLimit
+	globalRateLimit            RateLimit
+	dailyRowQuota              int
+	dailyOutputQuota           int64
+	cleanupCron                string
+	schedules                  bool
+	maxRows                    int
+	collectionMaxRows          map[string]int
+	authCollectionMaxRows      map[string]int
+	fieldPolicies              map[string]map[string]FieldPolicy
+	allowedCollections         []string
+	deniedCollections          []string
+	collectionConfigs          map[string]CollectionConfig
+	downloadTokenSecret        string
+	downloadLinkDuration       time.Duration
+	notifyEmailLinkDuration    time.Duration
+	notifyEmailAttachmentMax   int64
+	notifyEmailSuccessSubject  string
+	notifyEmailSuccessBody     string
+	notifyEmailFailureSubject  string
+	notifyEmailFailureBody     string
+	webhooks                   []string
+	webhookSecret              string
+	webhookAllowlist           []string
+	webhookMaxAttempts         int
+	webhookBackoff             time.Duration
+	destinations               map[string]ExportDestination
+	keepDestinationOutput      bool
 }
 
 var defaultRegisterConfig = registerConfig{
 	generateOutputInBackground: false,
 	autoDelete:                 true,
 	autoDeleteDuration:         time.Hour,
+	cleanupCron:                "*/5 * * * *",
+	schedules:                  true,
+	downloadLinkDuration:       5 * time.Minute,
+	notifyEmailLinkDuration:    24 * time.Hour,
+	notifyEmailAttachmentMax:   5 << 20,
+	notifyEmailSuccessSubject:  defaultNotifyEmailSuccessSubject,
+	notifyEmailSuccessBody:     defaultNotifyEmailSuccessBody,
+	notifyEmailFailureSubject:  defaultNotifyEmailFailureSubject,
+	notifyEmailFailureBody:     defaultNotifyEmailFailureBody,
+	webhookMaxAttempts:         5,
+	webhookBackoff:             10 * time.Second,
+	keepDestinationOutput:      true,
+}
+
+// exportMaxRows returns the maximum number of rows of the export,
+// the export collection override takes precedence over the auth collection one
+func (rc *registerConfig) exportMaxRows(export *Export) int {
+	if n, ok := rc.collectionMaxRows[export.ExportCollection().Name]; ok {
+		return n
+	}
+
+	if authRecord := export.AuthRecord(); authRecord != nil {
+		if n, ok := rc.authCollectionMaxRows[authRecord.Collection().Name]; ok {
+			return n
+		}
+	}
+
+	return rc.maxRows
 }
 
 // GenerateInBackground sets the generateOutputInBackground option
@@ -68,8 +315,8 @@ func GenerateInBackground(g bool) RegisterOption {
 }
 
 // AutoDelete sets the autoDelete option
-// if d is true, the export will be deleted automatically after
-// create new export
+// if d is true, the exports older than the autoDeleteDuration
+// are deleted by the cleanup job
 func AutoDelete(d bool) RegisterOption {
 	return func(rc *registerConfig) {
 		rc.autoDelete = d
@@ -83,6 +330,285 @@ func AutoDeleteDuration(d time.Duration) RegisterOption {
 	}
 }
 
+// CollectionRetention overrides how long the exports
+// of the collection are kept, 0 keeps them forever
+func CollectionRetention(collectionName string, d time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.collectionRetention == nil {
+			rc.collectionRetention = map[string]time.Duration{}
+		}
+
+		rc.collectionRetention[collectionName] = d
+	}
+}
+
+// AuthCollectionRetention overrides how long the exports owned
+// by the records of the auth collection are kept, 0 keeps them forever
+func AuthCollectionRetention(authCollectionName string, d time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.authCollectionRetention == nil {
+			rc.authCollectionRetention = map[string]time.Duration{}
+		}
+
+		rc.authCollectionRetention[authCollectionName] = d
+	}
+}
+
+// MaxExportsPerOwner sets the maximum number of exports kept per owner,
+// the oldest exports are deleted first, 0 means unlimited
+func MaxExportsPerOwner(n int) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.maxExportsPerOwner = n
+	}
+}
+
+// OwnerStorageQuota sets the maximum output size in bytes kept per owner,
+// the oldest exports are deleted first, 0 means unlimited
+func OwnerStorageQuota(n int64) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.ownerStorageQuota = n
+	}
+}
+
+// DedupeWindow sets how long an export is reused by the exports created with the same fingerprint,
+// they get the output of a succeeded export or wait for a pending one, 0 disables the deduplication
+func DedupeWindow(d time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.dedupeWindow = d
+	}
+}
+
+// OwnerRateLimit sets the maximum number of exports an auth record can create
+// per minute and per hour, 0 means unlimited
+func OwnerRateLimit(perMinute, perHour int) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.ownerRateLimit = RateLimit{PerMinute: perMinute, PerHour: perHour}
+	}
+}
+
+// GlobalRateLimit sets the maximum number of exports the auth records can create
+// per minute and per hour, 0 means unlimited
+func GlobalRateLimit(perMinute, perHour int) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.globalRateLimit = RateLimit{PerMinute: perMinute, PerHour: perHour}
+	}
+}
+
+// DailyRowQuota sets the maximum number of rows an auth record can export per UTC day,
+// 0 means unlimited
+func DailyRowQuota(n int) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.dailyRowQuota = n
+	}
+}
+
+// DailyOutputQuota sets the output size in bytes after which an auth record cannot create exports
+// until the next UTC day, 0 means unlimited
+func DailyOutputQuota(n int64) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.dailyOutputQuota = n
+	}
+}
+
+// CleanupCron sets the cron expression of the cleanup job
+func CleanupCron(expr string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.cleanupCron = expr
+	}
+}
+
+// Schedules sets the schedules option
+// if s is true, the exports of the schedules collection are created
+// by a scheduler started with the app
+func Schedules(s bool) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.schedules = s
+	}
+}
+
+// MaxRows sets the maximum number of rows of an export,
+// 0 means unlimited
+func MaxRows(n int) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.maxRows = n
+	}
+}
+
+// CollectionMaxRows overrides the maximum number of rows
+// of the exports of the collection, 0 means unlimited
+func CollectionMaxRows(collectionName string, n int) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.collectionMaxRows == nil {
+			rc.collectionMaxRows = map[string]int{}
+		}
+
+		rc.collectionMaxRows[collectionName] = n
+	}
+}
+
+// AuthCollectionMaxRows overrides the maximum number of rows
+// of the exports owned by the records of the auth collection, 0 means unlimited
+func AuthCollectionMaxRows(authCollectionName string, n int) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.authCollectionMaxRows == nil {
+			rc.authCollectionMaxRows = map[string]int{}
+		}
+
+		rc.authCollectionMaxRows[authCollectionName] = n
+	}
+}
+
+// AllowedCollections sets the only collections that can be exported,
+// by default every collection can be exported
+func AllowedCollections(collectionNames ...string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.allowedCollections = append(rc.allowedCollections, collectionNames...)
+	}
+}
+
+// DeniedCollections sets the collections that can never be exported,
+// it takes precedence over the allowed collections
+func DeniedCollections(collectionNames ...string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.deniedCollections = append(rc.deniedCollections, collectionNames...)
+	}
+}
+
+// CollectionExportConfig sets the export configuration of the collection
+func CollectionExportConfig(collectionName string, config CollectionConfig) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.collectionConfigs == nil {
+			rc.collectionConfigs = map[string]CollectionConfig{}
+		}
+
+		rc.collectionConfigs[collectionName] = config
+		if config.MaxRows != 0 {
+			CollectionMaxRows(collectionName, config.MaxRows)(rc)
+		}
+	}
+}
+
+// CollectionFieldPolicy sets the field policy of the collection for the exports owned
+// by the records of the auth collection, AnyAuthCollection applies to every auth collection
+// without its own policy. The admin exports have no policy.
+func CollectionFieldPolicy(collectionName, authCollectionName string, policy FieldPolicy) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.fieldPolicies == nil {
+			rc.fieldPolicies = map[string]map[string]FieldPolicy{}
+		}
+
+		if rc.fieldPolicies[collectionName] == nil {
+			rc.fieldPolicies[collectionName] = map[string]FieldPolicy{}
+		}
+
+		rc.fieldPolicies[collectionName][authCollectionName] = policy
+	}
+}
+
+// DownloadTokenSecret sets the secret used to sign the download links,
+// by default the app record file token secret is used
+func DownloadTokenSecret(secret string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.downloadTokenSecret = secret
+	}
+}
+
+// DownloadLinkDuration sets how long the download links
+// issued to the export owners are valid
+func DownloadLinkDuration(d time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.downloadLinkDuration = d
+	}
+}
+
+// NotifyEmailLinkDuration sets how long the download link
+// of the notification email is valid
+func NotifyEmailLinkDuration(d time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.notifyEmailLinkDuration = d
+	}
+}
+
+// NotifyEmailAttachmentMaxSize sets the maximum output size in bytes
+// attached to the notification email instead of a download link,
+// 0 never attaches the output
+func NotifyEmailAttachmentMaxSize(n int64) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.notifyEmailAttachmentMax = n
+	}
+}
+
+// NotifyEmailSuccessTemplate sets the subject and html body templates
+// of the email sent when an export succeeds, see NotifyEmailData
+func NotifyEmailSuccessTemplate(subject, body string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.notifyEmailSuccessSubject = subject
+		rc.notifyEmailSuccessBody = body
+	}
+}
+
+// NotifyEmailFailureTemplate sets the subject and html body templates
+// of the email sent when an export fails, see NotifyEmailData
+func NotifyEmailFailureTemplate(subject, body string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.notifyEmailFailureSubject = subject
+		rc.notifyEmailFailureBody = body
+	}
+}
+
+// Webhooks adds urls notified of every generated export
+func Webhooks(urls ...string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.webhooks = append(rc.webhooks, urls...)
+	}
+}
+
+// WebhookSecret sets the secret used to sign the webhook payloads,
+// the payloads are not signed without secret
+func WebhookSecret(secret string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.webhookSecret = secret
+	}
+}
+
+// WebhookAllowlist adds the hosts allowed in the webhook url of the exports,
+// a host can start with "*." to allow its subdomains,
+// the exports can not set a webhook url without allowlist
+func WebhookAllowlist(hosts ...string) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.webhookAllowlist = append(rc.webhookAllowlist, hosts...)
+	}
+}
+
+// WebhookRetry sets the maximum number of webhook delivery attempts
+// and the backoff before the first retry, doubled after every retry
+func WebhookRetry(maxAttempts int, backoff time.Duration) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.webhookMaxAttempts = maxAttempts
+		rc.webhookBackoff = backoff
+	}
+}
+
+// Destination registers a destination the exports can be delivered to by name
+func Destination(name string, d ExportDestination) RegisterOption {
+	return func(rc *registerConfig) {
+		if rc.destinations == nil {
+			rc.destinations = map[string]ExportDestination{}
+		}
+
+		rc.destinations[name] = d
+	}
+}
+
+// KeepDestinationOutput sets the keepDestinationOutput option
+// if k is false, the exports delivered to a destination
+// are not stored in the output field
+func KeepDestinationOutput(k bool) RegisterOption {
+	return func(rc *registerConfig) {
+		rc.keepDestinationOutput = k
+	}
+}
+
 // Register registers the pocketexport app with the core.App
 func Register(app core.App, opts ...RegisterOption) error {
 	return New(app).Register(opts...)
@@ -98,12 +624,26 @@ type IPocketExport interface {
 }
 
 type PocketExport struct {
-	app core.App
+	app    core.App
+	config registerConfig
+
+	scheduleMu     sync.Mutex
+	downloadMu     sync.Mutex
+	cleanupMu      sync.Mutex
+	emailTemplates *notifyEmailTemplates
+	usage          *exportUsage
+	inflightMu     sync.Mutex
+	inflight       map[string]*inflightExport
 }
 
 // New creates a new pocketexport
 func New(app core.App) *PocketExport {
-	return &PocketExport{app: app}
+	return &PocketExport{
+		app:      app,
+		config:   defaultRegisterConfig,
+		usage:    newExportUsage(),
+		inflight: map[string]*inflightExport{},
+	}
 }
 
 // ValidateRecord implement PocketExport interface
@@ -116,135 +656,377 @@ func (p *PocketExport) GenerateExportOutput(dst io.Writer, r *Export) error {
 	return p.generateExportOutput(dst, r)
 }
 
+// GenerateExportPreview generates the header labels and
+// the first limit formatted rows of the export
+func (p *PocketExport) GenerateExportPreview(r *Export, limit int) (*ExportPreview, error) {
+	return p.generateExportPreview(r, limit)
+}
+
+// ExportableFields returns the tree of exportable fields of the collection
+// for the auth record or admin, single relations are expanded up to depth
+func (p *PocketExport) ExportableFields(
+	collection *models.Collection,
+	authRecord *models.Record,
+	admin *models.Admin,
+	depth int,
+) ([]*ExportField, error) {
+	return p.exportableFields(collection, authRecord, admin, depth)
+}
+
 // Register implement PocketExport interface
 func (p *PocketExport) Register(opts ...RegisterOption) error {
-	rc := defaultRegisterConfig
 	for _, opt := range opts {
-		opt(&rc)
+		opt(&p.config)
 	}
+	rc := p.config
 
-	getFile := func(export *Export) (*filesystem.File, error) {
-		buf := bytes.NewBuffer(nil)
-		if err := p.GenerateExportOutput(buf, export); err != nil {
-			return nil, err
-		}
+	emailTemplates, err := newNotifyEmailTemplates(&rc)
+	if err != nil {
+		return err
+	}
+	p.emailTemplates = emailTemplates
 
-		file, err := filesystem.NewFileFromBytes(buf.Bytes(), export.GetString(OutputField))
-		if err != nil {
-			return nil, err
-		}
+	p.bindApis()
+	p.bindDownloads()
+	p.bindShares()
+	p.bindAudit()
+	p.bindSettings()
+	p.bindWebhooks()
 
-		// ensure file name is original name
-		file.Name = file.OriginalName
-		return file, nil
+	if rc.schedules {
+		p.bindSchedules()
 	}
 
 	// validate export records
-	p.app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) (err error) {
+	p.app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) error {
 		if e.Record.TableName() != PocketExportCollectionName {
 			return nil
 		}
 
-		filename := security.RandomString(20)
-		switch e.Record.GetString(FormatField) {
-		case FormatCSV:
-			filename += ".csv"
-		case FormatXLSX:
-			filename += ".xlsx"
+		return p.prepareExport(e, rc.generateOutputInBackground)
+	})
+
+	// the stored exports are counted from the exports table
+	p.app.OnModelAfterCreate().Add(func(e *core.ModelEvent) error {
+		if e.Model.TableName() == PocketExportCollectionName {
+			p.usage.release(e.Model.GetId())
 		}
 
-		e.Record.Set(OutputField, filename)
-		export, err := p.ValidateAndFill(e.Record)
-		if err != nil {
-			return err
+		return nil
+	})
+
+	// after create export audit it, generate output or deliver the webhooks of the generated output
+	p.app.OnRecordAfterCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		if e.Record.TableName() != PocketExportCollectionName {
+			return nil
 		}
 
-		if rc.generateOutputInBackground {
+		p.auditExport(e.HttpContext, AuditActionCreate, e.Record, "")
+		if e.Record.GetString(DuplicateOfField) != "" {
+			if e.Record.GetString(StatusField) != StatusPending {
+				p.fireExportWebhooks(e.Record)
+			}
 			return nil
 		}
 
-		file, err := getFile(export)
-		if err != nil {
-			return err
+		if !rc.generateOutputInBackground {
+			p.auditExport(e.HttpContext, AuditActionGenerate, e.Record, "")
+			p.fireExportWebhooks(e.Record)
+			return nil
 		}
 
-		// upload file to filesystem
-		e.UploadedFiles[OutputField] = []*filesystem.File{file}
-		return
+		recordId := e.Record.GetId()
+		routine.FireAndForget(func() {
+			if err := p.processExport(recordId); err != nil {
+				log.Printf("pocketexport: %v", err)
+			}
+		})
+
+		return nil
 	})
 
-	// after create export generate output
-	if rc.generateOutputInBackground {
-		p.app.OnRecordAfterCreateRequest().Add(func(e *core.RecordCreateEvent) error {
-			if e.Record.TableName() != PocketExportCollectionName {
-				return nil
+	// resume the exports that were pending when the app stopped
+	p.app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		startedAt := time.Now()
+		routine.FireAndForget(func() {
+			if err := p.resumePendingExports(startedAt); err != nil {
+				log.Printf("pocketexport: resume exports failed: %v", err)
 			}
+		})
 
-			recordId := e.Record.GetId()
-			routine.FireAndForget(func() {
-				record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, recordId)
-				if err != nil {
-					log.Printf("pocketexport: find record failed: %v", err)
-					return
-				}
-
-				export := NewExport(record)
-				if err = export.Fill(p.app.Dao()); err != nil {
-					log.Printf("pocketexport: fill export failed: %v", err)
-					return
-				}
-
-				file, err := getFile(export)
-				if err != nil {
-					log.Printf("pocketexport: upload file failed: %v", err)
-					return
-				}
-
-				fs, err := p.app.NewFilesystem()
-				if err != nil {
-					log.Printf("pocketexport: get filesystem failed: %v", err)
-					return
-				}
-
-				fileKey := record.BaseFilesPath() + "/" + file.Name
-				if err = fs.UploadFile(file, fileKey); err != nil {
-					log.Printf("pocketexport: upload file failed: %v", err)
-				}
-			})
+		return nil
+	})
 
-			return nil
-		})
+	// delete old exports in background
+	if err := p.bindCleanup(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// exportOutputFilename returns a random output file name for the format
+func exportOutputFilename(format string) string {
+	filename := security.RandomString(20)
+	switch format {
+	case FormatCSV:
+		filename += ".csv"
+	case FormatXLSX:
+		filename += ".xlsx"
+	case FormatJSON:
+		filename += ".json"
+	}
+
+	return filename
+}
+
+// generateExportFile generates the export output as a file named after the output field
+func (p *PocketExport) generateExportFile(export *Export) (*filesystem.File, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := p.GenerateExportOutput(buf, export); err != nil {
+		return nil, err
+	}
+
+	return newExportOutputFile(buf.Bytes(), export.GetString(OutputField))
+}
+
+// recoverExportPanic converts a panic of the export generation to the error err
+func recoverExportPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("generate export panicked: %v", r)
+	}
+}
+
+// newExportOutputFile creates the export output file of the content named name
+func newExportOutputFile(content []byte, name string) (*filesystem.File, error) {
+	file, err := filesystem.NewFileFromBytes(content, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure file name is original name
+	file.Name = file.OriginalName
+	return file, nil
+}
+
+// uploadExportOutput generates and uploads the output of a saved export record
+// to its destination and the filesystem, the record is not saved
+func (p *PocketExport) uploadExportOutput(record *models.Record) error {
+	export := NewExport(record)
+	if err := export.Fill(p.app.Dao()); err != nil {
+		return fmt.Errorf("fill export failed: %w", err)
+	}
+
+	file, err := p.generateExportFile(export)
+	if err != nil {
+		return fmt.Errorf("generate file failed: %w", err)
+	}
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return fmt.Errorf("checksum file failed: %w", err)
+	}
+	record.Set(ChecksumField, checksum)
+
+	keepOutput, err := p.uploadExportDestination(record, file)
+	if err != nil || !keepOutput {
+		return err
+	}
+
+	fs, err := p.app.NewFilesystem()
+	if err != nil {
+		return fmt.Errorf("get filesystem failed: %w", err)
+	}
+	defer fs.Close()
+
+	fileKey := record.BaseFilesPath() + "/" + file.Name
+	if err = fs.UploadFile(file, fileKey); err != nil {
+		return fmt.Errorf("upload file failed: %w", err)
+	}
+	record.Set(OutputSizeField, file.Size)
+
+	return nil
+}
+
+// processExport generates and uploads the output of a saved export record,
+// records the generation status and notifies the owner and the webhooks
+func (p *PocketExport) processExport(recordId string) error {
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, recordId)
+	if err != nil {
+		return fmt.Errorf("find record failed: %w", err)
+	}
+
+	genErr := func() (err error) {
+		defer recoverExportPanic(&err)
+		return p.uploadExportOutput(record)
+	}()
+
+	record.Set(StatusField, StatusSuccess)
+	record.Set(ErrorField, "")
+	if genErr != nil {
+		record.Set(StatusField, StatusFailed)
+		record.Set(ErrorField, genErr.Error())
+	}
+
+	if err := p.app.Dao().SaveRecord(record); err != nil {
+		return fmt.Errorf("save status failed: %w", err)
+	}
+	p.auditExport(nil, AuditActionGenerate, record, "")
+	p.completeDuplicateExports(record)
+
+	if err := p.notifyExportEmail(record); err != nil {
+		log.Printf("pocketexport: notify email failed: %v", err)
+	}
+	p.fireExportWebhooks(record)
+
+	return genErr
+}
+
+// resumePendingExports generates again the exports pending since before the start, the pending duplicates
+// complete with their original export or fail when it no longer exists,
+// the exports created after the start are generated by their own request
+func (p *PocketExport) resumePendingExports(startedAt time.Time) error {
+	dao := p.app.Dao()
+	before, err := types.ParseDateTime(startedAt)
+	if err != nil {
+		return err
+	}
+
+	records, err := dao.FindRecordsByExpr(
+		PocketExportCollectionName,
+		dbx.HashExp{StatusField: StatusPending},
+		dbx.NewExp("[[created]] < {:before}", dbx.Params{"before": before.String()}),
+	)
+	if err != nil {
+		return err
 	}
 
-	// after create delete old exports
-	if rc.autoDelete {
-		p.app.OnModelAfterCreate().Add(func(e *core.ModelEvent) error {
-			if e.Model.TableName() != PocketExportCollectionName {
-				return nil
+	originals := map[string]bool{}
+	for _, record := range records {
+		if record.GetString(DuplicateOfField) == "" {
+			originals[record.Id] = true
+			if err := p.processExport(record.Id); err != nil {
+				log.Printf("pocketexport: %v", err)
 			}
+		}
+	}
+
+	for _, record := range records {
+		originalId := record.GetString(DuplicateOfField)
+		if originalId == "" || originals[originalId] {
+			continue
+		}
+		originals[originalId] = true
+
+		original, err := dao.FindRecordById(PocketExportCollectionName, originalId)
+		if err == nil {
+			p.completeDuplicateExports(original)
+			continue
+		}
 
-			dao := p.app.Dao()
-			records, err := dao.FindRecordsByExpr(
-				PocketExportCollectionName,
-				dbx.NewExp(
-					PocketExportCollectionName+".created <= {:date}",
-					dbx.Params{"date": time.Now().UTC().Add(-rc.autoDeleteDuration).Format(types.DefaultDateLayout)},
-				),
-			)
-			if err != nil {
+		// the duplicates of a deleted export are not generated
+		duplicates, err := dao.FindRecordsByExpr(PocketExportCollectionName, dbx.HashExp{
+			DuplicateOfField: originalId,
+			StatusField:      StatusPending,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, duplicate := range duplicates {
+			duplicate.Set(StatusField, StatusFailed)
+			duplicate.Set(ErrorField, "the original export no longer exists")
+			duplicate.Set(OutputField, "")
+			if err := dao.SaveRecord(duplicate); err != nil {
 				return err
 			}
+			p.fireExportWebhooks(duplicate)
+		}
+	}
 
-			for _, r := range records {
-				if err := dao.DeleteRecord(r); err != nil {
-					return err
-				}
-			}
+	return nil
+}
 
-			return nil
-		})
+// prepareExport validates a new export record, resets its generated fields, enforces the limits,
+// dedupes it and generates its output unless in background,
+// shared by the create requests and the scheduled runs
+func (p *PocketExport) prepareExport(e *core.RecordCreateEvent, background bool) (err error) {
+	e.Record.Set(OutputField, exportOutputFilename(e.Record.GetString(FormatField)))
+	e.Record.Set(ScheduleIdField, "")
+	e.Record.Set(ErrorField, "")
+	e.Record.Set(WebhookAttemptsField, 0)
+	e.Record.Set(WebhookErrorField, "")
+	e.Record.Set(WebhookNextRetryAtField, "")
+	e.Record.Set(WebhookDeliveredAtField, "")
+	e.Record.Set(DestinationKeyField, "")
+	e.Record.Set(DownloadCountField, 0)
+	e.Record.Set(LastDownloadedAtField, "")
+	e.Record.Set(DownloadNoncesField, nil)
+	e.Record.Set(OutputSizeField, 0)
+	e.Record.Set(ChecksumField, "")
+	e.Record.Set(DuplicateOfField, "")
+
+	// check the rate limits before the rows are counted
+	if err := p.checkExportRateLimits(exportOwnerKey(e.Record)); err != nil {
+		return err
 	}
 
+	export, err := p.ValidateAndFill(e.Record)
+	if err != nil {
+		return err
+	}
+
+	if original, err := p.findDuplicateExport(e.Record); err != nil {
+		return err
+	} else if original != nil {
+		return p.attachDuplicateExport(e, original)
+	}
+
+	// the usage is held until the export is stored, the failed saves release it when the request is done
+	if err := p.reserveExport(e.Record); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			p.releaseExport(e.Record)
+		} else if e.HttpContext != nil {
+			p.releaseExportOnDone(e.HttpContext.Request().Context(), e.Record.Id)
+		}
+	}()
+
+	if background {
+		e.Record.Set(StatusField, StatusPending)
+		return nil
+	}
+
+	// the record is saved afterwards, assign the id now so
+	// the destination key can reference it
+	if !e.Record.HasId() {
+		e.Record.RefreshId()
+	}
+
+	file, err := p.generateExportFileOnce(export)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	e.Record.Set(ChecksumField, checksum)
+
+	keepOutput, err := p.uploadExportDestination(e.Record, file)
+	if err != nil {
+		return err
+	}
+	e.Record.Set(StatusField, StatusSuccess)
+
+	// upload file to filesystem
+	if keepOutput {
+		e.Record.Set(OutputSizeField, file.Size)
+		e.UploadedFiles[OutputField] = []*filesystem.File{file}
+	}
 	return nil
 }
 
@@ -253,6 +1035,58 @@ type HeaderItem struct {
 	Header    string         `json:"header"`
 	Timezone  string         `json:"timezone"`
 	ValueMap  map[string]any `json:"valueMap"`
+	// Expression computes the value instead of the field, see ParseExpression
+	Expression string `json:"expression"`
+	// Template renders the formatted value with text/template, see HeaderTemplateData
+	Template string `json:"template"`
+	// DateLayout is the Go layout or the strftime-like layout of the dates, RFC3339 by default
+	DateLayout string `json:"dateLayout"`
+	// Locale is the BCP 47 locale of the numbers, eg. "vi" formats 1234.5 as "1.234,5"
+	Locale string `json:"locale"`
+	// Decimals is the fixed number of decimals of the numbers
+	Decimals *int `json:"decimals"`
+	// Currency is the ISO 4217 currency of the numbers, eg. "VND"
+	Currency string `json:"currency"`
+	// Aggregate is the aggregation mode of the multi-valued fields, eg. the multi relations and selects
+	Aggregate string `json:"aggregate"`
+	// Separator is the separator of the joined values, ", " by default
+	Separator string `json:"separator"`
+
+	expression *Expression
+	template   *template.Template
+	printer    *message.Printer
+}
+
+// ParsedExpression returns the parsed expression of the header, nil if the header is a field
+func (i *HeaderItem) ParsedExpression() (*Expression, error) {
+	if i.Expression == "" || i.expression != nil {
+		return i.expression, nil
+	}
+
+	expression, err := ParseExpression(i.Expression)
+	if err != nil {
+		return nil, errInvalidExpression.SetParams(map[string]any{
+			"expression": i.Expression,
+			"error":      err.Error(),
+		})
+	}
+	i.expression = expression
+
+	return expression, nil
+}
+
+// FieldNames returns the field paths of the header, the fields of its expression if it has one
+func (i *HeaderItem) FieldNames() ([]string, error) {
+	expression, err := i.ParsedExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if expression == nil {
+		return []string{i.FieldName}, nil
+	}
+
+	return expression.Fields(), nil
 }
 
 // Format formats the value.
@@ -263,11 +1097,11 @@ func (i *HeaderItem) Format(value any) any {
 	}
 
 	if v, ok := value.(time.Time); ok {
-		value = v.In(location).Format(time.RFC3339)
+		value = v.In(location).Format(goDateLayout(i.DateLayout))
 	}
 
 	if v, ok := value.(types.DateTime); ok {
-		value = v.Time().In(location).Format(time.RFC3339)
+		value = v.Time().In(location).Format(goDateLayout(i.DateLayout))
 	}
 
 	if len(i.ValueMap) > 0 {
@@ -276,9 +1110,22 @@ func (i *HeaderItem) Format(value any) any {
 		}
 	}
 
+	if i.formatsNumbers() {
+		if v, ok := i.formatNumber(value); ok {
+			value = v
+		}
+	}
+
 	return value
 }
 
+// ExportPreview represents the first rows of an export
+type ExportPreview struct {
+	Headers    []string `json:"headers"`
+	Rows       [][]any  `json:"rows"`
+	TotalItems int      `json:"totalItems"`
+}
+
 // Export represents an export
 type Export struct {
 	*models.Record
@@ -315,9 +1162,14 @@ func (e *Export) Fill(dao *daos.Dao) (err error) {
 		}
 	}
 
-	if err := e.UnmarshalJSONField(HeadersField, &e.headers); err != nil {
-		return err
+	// the headers can be empty, see CollectionConfig.DefaultHeaders
+	e.headers = nil
+	if e.Record.GetString(HeadersField) != "" {
+		if err := e.UnmarshalJSONField(HeadersField, &e.headers); err != nil {
+			return err
+		}
 	}
+	e.applyHeaderDefaults()
 
 	e.exportCollection = exportCollection
 	e.authRecord = authRecord
@@ -326,6 +1178,20 @@ func (e *Export) Fill(dao *daos.Dao) (err error) {
 	return nil
 }
 
+// applyHeaderDefaults sets the export timezone and locale to the headers without them
+func (e *Export) applyHeaderDefaults() {
+	timezone := e.Record.GetString(DefaultTimezoneField)
+	locale := e.Record.GetString(LocaleField)
+	for i := range e.headers {
+		if e.headers[i].Timezone == "" {
+			e.headers[i].Timezone = timezone
+		}
+		if e.headers[i].Locale == "" {
+			e.headers[i].Locale = locale
+		}
+	}
+}
+
 // ExportCollection  return the export collection
 func (e *Export) ExportCollection() *models.Collection {
 	return e.exportCollection
@@ -3,6 +3,7 @@ package pocketexport
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 func getExportRecord(t *testing.T, app core.App) *models.Record {
@@ -130,6 +132,40 @@ func Test_pocketExport_ValidateAndFill(t *testing.T) {
 		t.Fatal("wrong headers")
 	}
 
+	record = getExportRecord(t, testApp)
+	if _, err := exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	} else if record.GetInt(RowCountField) != 2 {
+		t.Fatalf("expect row count 2, got %d", record.GetInt(RowCountField))
+	}
+
+	limitedService := New(testApp)
+	MaxRows(1)(&limitedService.config)
+	record = getExportRecord(t, testApp)
+	if _, err := limitedService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[FilterField].(validation.Error).Code() != "validation_too_many_rows" {
+		t.Fatal(err)
+	}
+
+	CollectionMaxRows("messages", 0)(&limitedService.config)
+	record = getExportRecord(t, testApp)
+	if _, err := limitedService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	limitedService = New(testApp)
+	AuthCollectionMaxRows("users", 1)(&limitedService.config)
+	record = getExportRecord(t, testApp)
+	if _, err := limitedService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+	record.Set(OwnerIdField, "djh54wc2hpkhfkw")
+	record.Set(OwnerCollectionNameField, "users")
+	if _, err := limitedService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	}
+
 	record = getExportRecord(t, testApp)
 	record.Set(ExportCollectionNameField, "wrong")
 	if _, err := exportService.ValidateAndFill(record); err == nil {
@@ -241,3 +277,206 @@ func Test_pocketExport_GenerateExportOutput(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// flushRecorder records the lines written before every flush
+type flushRecorder struct {
+	bytes.Buffer
+	flushes []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes = append(r.flushes, strings.Count(r.String(), "\n"))
+}
+
+func Test_pocketExport_GenerateExportOutputFlush(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	messages, err := testApp.Dao().FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 pages of messages
+	for i := 0; i < generateExportPerPage; i++ {
+		message := models.NewRecord(messages)
+		message.Set("message", "bulk")
+		if err := testApp.Dao().SaveRecord(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(HeadersField, []any{map[string]any{"fieldName": "message", "header": "nội dung"}})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &flushRecorder{}
+	if err := exportService.GenerateExportOutput(recorder, export); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header and a page of rows, then the last page
+	if len(recorder.flushes) != 2 || recorder.flushes[0] != 1+generateExportPerPage || recorder.flushes[1] != 3+generateExportPerPage {
+		t.Fatalf("expect a flush per page, got %v", recorder.flushes)
+	}
+}
+
+func Test_pocketExport_AuthFieldVisibility(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	users, err := testApp.Dao().FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.ViewRule = types.Pointer("")
+	if err := testApp.Dao().SaveCollection(users); err != nil {
+		t.Fatal(err)
+	}
+
+	user2, err := testApp.Dao().FindRecordById("users", "djh54wc2hpkhfkw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2.SetEmailVisibility(false)
+	if err := testApp.Dao().SaveRecord(user2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the email of test2 is not visible to test1
+	record := getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "author.email", "header": "thư diện tử"},
+		map[string]any{"fieldName": "author.name", "header": "tên tác giả"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "thư diện tử,tên tác giả\ntest1@gmail.com,test1\n,test2\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the admins see every email
+	record.Set(OwnerIdField, "x9fs8mten7zmwcv")
+	record.Set(OwnerCollectionNameField, "")
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = "thư diện tử,tên tác giả\ntest1@gmail.com,test1\ntest2@gmail.com,test2\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// hidden auth field
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "author.tokenKey", "header": "token"},
+	})
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[HeadersField].(validation.Error).Code() != "validation_field_not_visible" {
+		t.Fatal(err)
+	}
+
+	// admin only related collection
+	users.ViewRule = nil
+	if err := testApp.Dao().SaveCollection(users); err != nil {
+		t.Fatal(err)
+	}
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "author.name", "header": "tên tác giả"},
+	})
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[HeadersField].(validation.Error).Code() != "validation_field_not_visible" {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_ResumePendingExports(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	startedAt := time.Now()
+	newRecord := func(id string, duplicateOf string) *models.Record {
+		record := getExportRecord(t, testApp)
+		record.Id = id
+		record.Set("created", startedAt.Add(-time.Minute))
+		record.Set(OutputField, exportOutputFilename(FormatCSV))
+		record.Set(StatusField, StatusPending)
+		record.Set(DuplicateOfField, duplicateOf)
+		if _, err := exportService.ValidateAndFill(record); err != nil {
+			t.Fatal(err)
+		}
+		if err := testApp.Dao().SaveRecord(record); err != nil {
+			t.Fatal(err)
+		}
+
+		return record
+	}
+
+	// the app stopped while generating
+	original := newRecord("original", "")
+	duplicate := newRecord("duplicate", original.Id)
+	orphan := newRecord("orphan", "deleted")
+
+	// created by a request after the start
+	late := newRecord("late", "")
+	late.Set("created", startedAt.Add(time.Second))
+	if err := testApp.Dao().SaveRecord(late); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.resumePendingExports(startedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []struct {
+		record *models.Record
+		status string
+	}{
+		{original, StatusSuccess},
+		{duplicate, StatusSuccess},
+		{orphan, StatusFailed},
+		{late, StatusPending},
+	} {
+		record, err := testApp.Dao().FindRecordById(PocketExportCollectionName, s.record.Id)
+		if err != nil {
+			t.Fatal(err)
+		} else if record.GetString(StatusField) != s.status {
+			t.Fatalf("%s: expect %s, got %s", record.Id, s.status, record.GetString(StatusField))
+		}
+	}
+}
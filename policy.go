@@ -0,0 +1,311 @@
+package pocketexport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+// AnyAuthCollection is the auth collection name of the field policies
+// applied to the owners of every auth collection without their own policy
+const AnyAuthCollection = "*"
+
+var errFieldNotAllowed = validation.NewError("validation_field_not_allowed", "the field {{.field}} cannot be exported")
+
+// Mask is a masking transform of an exported value
+type Mask func(value any) any
+
+// FieldPolicy restricts the fields of a collection that the owners of an auth collection can export,
+// the fields are the names of the collection fields, they also apply when the collection is a related one
+type FieldPolicy struct {
+	// Allow lists the only exportable fields, empty allows every field
+	Allow []string
+	// Deny lists the fields that are never exportable
+	Deny []string
+	// Masks are the masking transforms of the exported fields
+	Masks map[string]Mask
+}
+
+// allows checks whether the field can be exported
+func (fp *FieldPolicy) allows(name string) bool {
+	for _, denied := range fp.Deny {
+		if denied == name {
+			return false
+		}
+	}
+
+	if len(fp.Allow) == 0 {
+		return true
+	}
+
+	for _, allowed := range fp.Allow {
+		if allowed == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMaskEmpty checks whether there is nothing to mask in the value
+func isMaskEmpty(value any) bool {
+	return value == nil || value == ""
+}
+
+// MaskLast keeps the last n characters of the value and replaces the others with '*'
+func MaskLast(n int) Mask {
+	return func(value any) any {
+		if isMaskEmpty(value) {
+			return value
+		}
+
+		runes := []rune(fmt.Sprintf("%v", value))
+		if len(runes) <= n {
+			return strings.Repeat("*", len(runes))
+		}
+
+		return strings.Repeat("*", len(runes)-n) + string(runes[len(runes)-n:])
+	}
+}
+
+// MaskHash replaces the value with its hex encoded sha256
+func MaskHash(value any) any {
+	if isMaskEmpty(value) {
+		return value
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%v", value)))
+	return hex.EncodeToString(hash[:])
+}
+
+// MaskEmail keeps the first character and the domain of an email, eg. j*******@example.com
+func MaskEmail(value any) any {
+	if isMaskEmpty(value) {
+		return value
+	}
+
+	email := fmt.Sprintf("%v", value)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return MaskLast(0)(email)
+	}
+
+	local := []rune(email[:at])
+	if len(local) == 0 {
+		return email
+	}
+
+	return string(local[0]) + strings.Repeat("*", len(local)-1) + email[at:]
+}
+
+// MaskRedact replaces the value with an empty string
+func MaskRedact(value any) any {
+	return ""
+}
+
+// fieldPolicy returns the field policy of the collection for the auth record,
+// admins have no policy
+func (rc *registerConfig) fieldPolicy(collectionName string, authRecord *models.Record) *FieldPolicy {
+	if authRecord == nil {
+		return nil
+	}
+
+	policies, ok := rc.fieldPolicies[collectionName]
+	if !ok {
+		return nil
+	}
+
+	if policy, ok := policies[authRecord.Collection().Name]; ok {
+		return &policy
+	}
+
+	if policy, ok := policies[AnyAuthCollection]; ok {
+		return &policy
+	}
+
+	return nil
+}
+
+// exportFieldCollections returns the collections of the field path, starting with the collection,
+// and the name of the last field, eg. messages, users and name for the author.name path of messages.
+// The path can start with a back-relation, eg. comments_via_post.created, and end with the json path
+// of a json field, then the name is the json field, eg. meta for author.meta.address.city
+func exportFieldCollections(dao *daos.Dao, collection *models.Collection, fieldName string) ([]*models.Collection, string, error) {
+	splitKey := strings.Split(backRelationFieldPath(collection, fieldName), ".")
+	collections := make([]*models.Collection, 0, len(splitKey))
+	collections = append(collections, collection)
+
+	for k, key := range splitKey[:len(splitKey)-1] {
+		if k == 0 && isBackRelationPath(collection, key) {
+			var err error
+			if collection, _, err = exportBackRelation(dao, collection, key); err != nil {
+				return nil, "", err
+			}
+			collections = append(collections, collection)
+			continue
+		}
+
+		// the json fields are followed by their json path
+		if isJSONField(collection, key) {
+			return collections, jsonPathFieldName(key), nil
+		}
+
+		field := collection.Schema.GetFieldByName(key)
+		if field == nil || field.Type != schema.FieldTypeRelation {
+			return nil, "", fmt.Errorf("%s is not a relation of %s", key, collection.Name)
+		}
+
+		field.InitOptions()
+		options, ok := field.Options.(*schema.RelationOptions)
+		if !ok {
+			return nil, "", fmt.Errorf("%s is not a relation of %s", key, collection.Name)
+		}
+
+		var err error
+		if collection, err = dao.FindCollectionByNameOrId(options.CollectionId); err != nil {
+			return nil, "", err
+		}
+		collections = append(collections, collection)
+	}
+
+	return collections, jsonPathFieldName(splitKey[len(splitKey)-1]), nil
+}
+
+// exportHeaderMasks returns the masking transform of every export header, nil if the header
+// is not masked, and fails if a header field is not allowed by the field policies of the owner
+// or is masked and used by an expression
+func (s *PocketExport) exportHeaderMasks(export *Export) ([]Mask, error) {
+	headers := export.Headers()
+	masks := make([]Mask, len(headers))
+	if export.AuthRecord() == nil || len(s.config.fieldPolicies) == 0 {
+		return masks, nil
+	}
+
+	for i := range headers {
+		fieldNames, err := headers[i].FieldNames()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fieldName := range fieldNames {
+			collections, name, err := exportFieldCollections(s.app.Dao(), export.ExportCollection(), fieldName)
+			if err != nil {
+				return nil, err
+			}
+
+			policy := s.config.fieldPolicy(collections[len(collections)-1].Name, export.AuthRecord())
+			if policy == nil {
+				continue
+			}
+
+			errNotAllowed := errFieldNotAllowed.SetParams(map[string]any{"field": fieldName})
+			if !policy.allows(name) {
+				return nil, errNotAllowed
+			}
+
+			mask := policy.Masks[name]
+			if mask == nil {
+				continue
+			}
+
+			// the masked fields cannot be computed, the expression could reveal them
+			if headers[i].Expression != "" {
+				return nil, errNotAllowed
+			}
+
+			masks[i] = mask
+		}
+	}
+
+	return masks, nil
+}
+
+// validateFieldPolicy checks that the field policies of the owner neither deny nor mask the field
+// of a filter or a sort, the @collection fields are checked with their collection and the
+// other @ fields are ignored
+func (s *PocketExport) validateFieldPolicy(export *Export, fieldName string) error {
+	if export.AuthRecord() == nil || len(s.config.fieldPolicies) == 0 {
+		return nil
+	}
+
+	dao := s.app.Dao()
+	collection := export.ExportCollection()
+	path := fieldName
+	if strings.HasPrefix(fieldName, "@") {
+		parts := strings.SplitN(fieldName, ".", 3)
+		if len(parts) != 3 || parts[0] != "@collection" {
+			return nil
+		}
+
+		var err error
+		if collection, err = dao.FindCollectionByNameOrId(parts[1]); err != nil {
+			// the invalid collections are reported by the field resolver
+			return nil
+		}
+		path = parts[2]
+	}
+
+	collections, name, err := exportFieldCollections(dao, collection, path)
+	if err != nil {
+		// the invalid field paths are reported by the field resolver
+		return nil
+	}
+
+	policy := s.config.fieldPolicy(collections[len(collections)-1].Name, export.AuthRecord())
+	if policy != nil && (!policy.allows(name) || policy.Masks[name] != nil) {
+		return errFieldNotAllowed.SetParams(map[string]any{"field": fieldName})
+	}
+
+	return nil
+}
+
+// exportPolicyFieldResolver is a field resolver keeping the first field
+// of a filter or a sort that the field policies of the owner deny or mask
+type exportPolicyFieldResolver struct {
+	search.FieldResolver
+
+	s      *PocketExport
+	export *Export
+	err    error
+}
+
+// Resolve checks the field policies and resolves the field
+func (r *exportPolicyFieldResolver) Resolve(field string) (*search.ResolverResult, error) {
+	if r.err == nil {
+		r.err = r.s.validateFieldPolicy(r.export, field)
+	}
+
+	return r.FieldResolver.Resolve(field)
+}
+
+// validateFilterAndSortPolicy checks that the export filter and sort do not use the fields
+// that the field policies of the owner deny or mask, their values would leak through the row count
+func (s *PocketExport) validateFilterAndSortPolicy(export *Export, fieldResolver search.FieldResolver) error {
+	if export.AuthRecord() == nil || len(s.config.fieldPolicies) == 0 {
+		return nil
+	}
+
+	resolver := &exportPolicyFieldResolver{FieldResolver: fieldResolver, s: s, export: export}
+	if filter := export.GetString(FilterField); filter != "" {
+		// the invalid filters are reported by the search provider
+		search.FilterData(filter).BuildExpr(resolver)
+		if resolver.err != nil {
+			return validation.Errors{FilterField: resolver.err}
+		}
+	}
+
+	for _, sortField := range search.ParseSortFromString(export.GetString(SortField)) {
+		if err := s.validateFieldPolicy(export, sortField.Name); err != nil {
+			return validation.Errors{SortField: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package pocketexport
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func Test_Mask(t *testing.T) {
+	scenarios := []struct {
+		mask   Mask
+		value  any
+		expect any
+	}{
+		{MaskLast(4), "4111111111111111", "************1111"},
+		{MaskLast(4), 123456, "**3456"},
+		{MaskLast(4), "123", "***"},
+		{MaskLast(4), "", ""},
+		{MaskEmail, "test1@gmail.com", "t****@gmail.com"},
+		{MaskEmail, "not an email", "************"},
+		{MaskHash, "secret", "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{MaskHash, nil, nil},
+		{MaskRedact, "secret", ""},
+	}
+
+	for i, s := range scenarios {
+		if v := s.mask(s.value); v != s.expect {
+			t.Fatalf("(%d) expect %v, got %v", i, s.expect, v)
+		}
+	}
+}
+
+func Test_pocketExport_FieldPolicy(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	record := getExportRecord(t, testApp)
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+
+	// the admin exports have no policy
+	CollectionFieldPolicy("users", AnyAuthCollection, FieldPolicy{Deny: []string{"email"}})(&exportService.config)
+	admin := getExportRecord(t, testApp)
+	if _, err := exportService.ValidateAndFill(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// denied related field
+	_, err = exportService.ValidateAndFill(record)
+	if errs, ok := err.(validation.Errors); !ok {
+		t.Fatalf("expect validation errors, got %v", err)
+	} else if e, ok := errs[HeadersField].(validation.Error); !ok || e.Code() != "validation_field_not_allowed" {
+		t.Fatalf("expect field not allowed, got %v", errs[HeadersField])
+	}
+
+	// not allowed field, the auth collection policy takes precedence
+	CollectionFieldPolicy("users", "users", FieldPolicy{})(&exportService.config)
+	CollectionFieldPolicy("messages", "users", FieldPolicy{Allow: []string{"message", "author"}})(&exportService.config)
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should not allow the created field")
+	}
+
+	// masked fields
+	CollectionFieldPolicy("messages", "users", FieldPolicy{
+		Masks: map[string]Mask{"message": MaskRedact},
+	})(&exportService.config)
+	CollectionFieldPolicy("users", "users", FieldPolicy{
+		Masks: map[string]Mask{"email": MaskEmail, "name": MaskLast(1)},
+	})(&exportService.config)
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "author.email", "header": "thư diện tử"},
+		map[string]any{"fieldName": "author.name", "header": "tên tác giả"},
+	})
+	record.Set(FilterField, `id != ""`)
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner cannot view the author of the second message
+	expect := "nội dung,thư diện tử,tên tác giả\n" +
+		",t****@gmail.com,****1\n" +
+		",,\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the filter and sort cannot use the denied or masked fields
+	CollectionFieldPolicy("messages", "users", FieldPolicy{Deny: []string{"message"}})(&exportService.config)
+	scenarios := []struct {
+		field string
+		value string
+	}{
+		{FilterField, `message = "test1"`},
+		{FilterField, `id != "" && @collection.messages.message = "test1"`},
+		{FilterField, `author.email ~ "test1"`},
+		{SortField, "created,-message"},
+	}
+
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		record.Set(HeadersField, []any{map[string]any{"fieldName": "created", "header": "x"}})
+		record.Set(FilterField, `id != ""`)
+		record.Set(s.field, s.value)
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[s.field].(validation.Error); !ok || e.Code() != "validation_field_not_allowed" {
+			t.Fatalf("(%d) expect field not allowed, got %v", i, err)
+		}
+	}
+}
+
+func Test_pocketExport_FieldPolicyFieldsApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+
+	scenario := tests.ApiScenario{
+		Name:           "denied fields",
+		Method:         http.MethodGet,
+		Url:            "/api/pocketexport/fields/messages",
+		RequestHeaders: map[string]string{"Authorization": userToken},
+		ExpectedStatus: http.StatusOK,
+		ExpectedContent: []string{
+			`{"fieldName":"author.name","name":"name","type":"text","system":false}`,
+		},
+		NotExpectedContent: []string{`"author.email"`},
+		TestAppFactory: newRegisteredTestApp(
+			CollectionFieldPolicy("users", "users", FieldPolicy{Deny: []string{"email"}}),
+		),
+	}
+
+	scenario.Test(t)
+}
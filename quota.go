@@ -0,0 +1,328 @@
+package pocketexport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// RateLimit is the maximum number of exports created per minute and per hour, 0 means unlimited
+type RateLimit struct {
+	PerMinute int
+	PerHour   int
+}
+
+// UsageItem is the usage of a limit, a Limit of 0 means unlimited and Remaining is then -1
+type UsageItem struct {
+	Used      int64 `json:"used"`
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+}
+
+// ExportUsage is the current export usage of an owner
+type ExportUsage struct {
+	Minute      UsageItem      `json:"minute"`
+	Hour        UsageItem      `json:"hour"`
+	Rows        UsageItem      `json:"rows"`
+	OutputBytes UsageItem      `json:"outputBytes"`
+	ResetsAt    types.DateTime `json:"resetsAt"`
+}
+
+// newUsageItem creates the usage item of the used limit
+func newUsageItem(used, limit int64) UsageItem {
+	item := UsageItem{Used: used, Limit: limit, Remaining: -1}
+	if limit > 0 {
+		item.Remaining = limit - used
+		if item.Remaining < 0 {
+			item.Remaining = 0
+		}
+	}
+
+	return item
+}
+
+// exportOwnerKey returns the usage key of the export owner, empty for the admins
+func exportOwnerKey(record *models.Record) string {
+	collectionName := record.GetString(OwnerCollectionNameField)
+	if collectionName == "" {
+		return ""
+	}
+
+	return collectionName + "/" + record.GetString(OwnerIdField)
+}
+
+// exportReservation is the usage held for an export until it is stored
+type exportReservation struct {
+	key      string
+	rowCount int
+}
+
+// exportUsage holds the usage of the exports being created, once stored the usage
+// is counted from the exports and the stream audits so it is kept across restarts and instances
+type exportUsage struct {
+	mu    sync.Mutex
+	holds map[string]exportReservation
+}
+
+// newExportUsage creates an empty export usage
+func newExportUsage() *exportUsage {
+	return &exportUsage{holds: map[string]exportReservation{}}
+}
+
+// release drops the usage held for the export record id
+func (u *exportUsage) release(recordId string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.holds, recordId)
+}
+
+// usageCounts is the number of exports of the last minute and hour
+// and the rows and output bytes of the current UTC day
+type usageCounts struct {
+	Minute int64 `db:"minute"`
+	Hour   int64 `db:"hour"`
+	Rows   int64 `db:"rows"`
+	Bytes  int64 `db:"bytes"`
+}
+
+func (c *usageCounts) add(o usageCounts) {
+	c.Minute += o.Minute
+	c.Hour += o.Hour
+	c.Rows += o.Rows
+	c.Bytes += o.Bytes
+}
+
+// usageDayStart returns the start of the UTC day of t
+func usageDayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// usageTimeParam formats t like the stored datetimes
+func usageTimeParam(t time.Time) string {
+	d, _ := types.ParseDateTime(t)
+	return d.String()
+}
+
+// countStoredUsage counts the stored exports and stream audits of the owner key,
+// all the auth records when key is empty, the failed exports and the duplicates are not charged
+func (p *PocketExport) countStoredUsage(key string, now time.Time) (usageCounts, error) {
+	since := now.Add(-time.Hour)
+	if dayStart := usageDayStart(now); dayStart.Before(since) {
+		since = dayStart
+	}
+
+	params := dbx.Params{
+		"minute": usageTimeParam(now.Add(-time.Minute)),
+		"hour":   usageTimeParam(now.Add(-time.Hour)),
+		"day":    usageTimeParam(usageDayStart(now)),
+		"since":  usageTimeParam(since),
+		"failed": StatusFailed,
+		"stream": AuditActionStream,
+	}
+
+	ownerExp, actorExp := "[[ownerCollectionName]] != ''", "[[actorCollectionName]] != ''"
+	if key != "" {
+		params["collection"], params["id"], _ = strings.Cut(key, "/")
+		ownerExp = "[[ownerCollectionName]] = {:collection} AND [[ownerId]] = {:id}"
+		actorExp = "[[actorCollectionName]] = {:collection} AND [[actorId]] = {:id}"
+	}
+
+	counts := usageCounts{}
+	queries := []string{
+		`SELECT
+			COALESCE(SUM(CASE WHEN [[created]] >= {:minute} THEN 1 ELSE 0 END), 0) AS [[minute]],
+			COALESCE(SUM(CASE WHEN [[created]] >= {:hour} THEN 1 ELSE 0 END), 0) AS [[hour]],
+			COALESCE(SUM(CASE WHEN [[created]] >= {:day} THEN [[rowCount]] ELSE 0 END), 0) AS [[rows]],
+			COALESCE(SUM(CASE WHEN [[created]] >= {:day} THEN [[outputSize]] ELSE 0 END), 0) AS [[bytes]]
+		FROM {{` + PocketExportCollectionName + `}}
+		WHERE [[created]] >= {:since} AND [[duplicateOf]] = '' AND [[status]] != {:failed} AND ` + ownerExp,
+		`SELECT
+			COALESCE(SUM(CASE WHEN [[created]] >= {:minute} THEN 1 ELSE 0 END), 0) AS [[minute]],
+			COALESCE(SUM(CASE WHEN [[created]] >= {:hour} THEN 1 ELSE 0 END), 0) AS [[hour]],
+			COALESCE(SUM(CASE WHEN [[created]] >= {:day} THEN [[rowCount]] ELSE 0 END), 0) AS [[rows]],
+			0 AS [[bytes]]
+		FROM {{` + PocketExportAuditCollectionName + `}}
+		WHERE [[created]] >= {:since} AND [[action]] = {:stream} AND ` + actorExp,
+	}
+	for _, query := range queries {
+		c := usageCounts{}
+		if err := p.app.Dao().DB().NewQuery(query).Bind(params).One(&c); err != nil {
+			return counts, err
+		}
+		counts.add(c)
+	}
+
+	return counts, nil
+}
+
+// countUsage counts the stored and the held usage of the owner key,
+// all the auth records when key is empty, p.usage.mu must be locked
+func (p *PocketExport) countUsage(key string, now time.Time) (usageCounts, error) {
+	counts, err := p.countStoredUsage(key, now)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, r := range p.usage.holds {
+		if key == "" || r.key == key {
+			counts.add(usageCounts{Minute: 1, Hour: 1, Rows: int64(r.rowCount)})
+		}
+	}
+
+	return counts, nil
+}
+
+// checkRateLimits checks the global rate limit and the rate limit of the owner key,
+// p.usage.mu must be locked
+func (p *PocketExport) checkRateLimits(key string, now time.Time) error {
+	exceeded := func(counts usageCounts, limit RateLimit) bool {
+		return (limit.PerMinute > 0 && counts.Minute >= int64(limit.PerMinute)) ||
+			(limit.PerHour > 0 && counts.Hour >= int64(limit.PerHour))
+	}
+
+	if limit := p.config.globalRateLimit; limit.PerMinute > 0 || limit.PerHour > 0 {
+		counts, err := p.countUsage("", now)
+		if err != nil {
+			return err
+		}
+		if exceeded(counts, limit) {
+			return apis.NewApiError(http.StatusTooManyRequests, "Too many exports, try again later.", nil)
+		}
+	}
+
+	if limit := p.config.ownerRateLimit; limit.PerMinute > 0 || limit.PerHour > 0 {
+		counts, err := p.countUsage(key, now)
+		if err != nil {
+			return err
+		}
+		if exceeded(counts, limit) {
+			return apis.NewApiError(http.StatusTooManyRequests, "You have created too many exports, try again later.", nil)
+		}
+	}
+
+	return nil
+}
+
+// checkExportRateLimits checks the rate limits of the owner key before the export is validated,
+// so the throttled requests do not count the rows, the admins have no limit
+func (p *PocketExport) checkExportRateLimits(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+
+	return p.checkRateLimits(key, time.Now())
+}
+
+// reserveExport enforces the rate limits and quotas of the export record owner, the admins have no limit,
+// the usage is held until the export is stored or released when it fails, the output bytes quota fails once it is reached
+func (p *PocketExport) reserveExport(record *models.Record) error {
+	key := exportOwnerKey(record)
+	if key == "" {
+		return nil
+	}
+
+	if !record.HasId() {
+		record.RefreshId()
+	}
+
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+
+	now := time.Now()
+	if err := p.checkRateLimits(key, now); err != nil {
+		return err
+	}
+
+	rowCount := record.GetInt(RowCountField)
+	if p.config.dailyRowQuota > 0 || p.config.dailyOutputQuota > 0 {
+		counts, err := p.countUsage(key, now)
+		if err != nil {
+			return err
+		}
+
+		if p.config.dailyRowQuota > 0 && counts.Rows+int64(rowCount) > int64(p.config.dailyRowQuota) {
+			return apis.NewApiError(http.StatusTooManyRequests, "The export exceeds your daily row quota.", nil)
+		}
+
+		if p.config.dailyOutputQuota > 0 && counts.Bytes >= p.config.dailyOutputQuota {
+			return apis.NewApiError(http.StatusTooManyRequests, "You have reached your daily output quota.", nil)
+		}
+	}
+
+	p.usage.holds[record.Id] = exportReservation{key: key, rowCount: rowCount}
+
+	return nil
+}
+
+// releaseExport drops the usage held for the export record, either it is stored
+// and counted from the exports or it failed and is not charged
+func (p *PocketExport) releaseExport(record *models.Record) {
+	p.usage.release(record.Id)
+}
+
+// releaseExportOnDone releases the usage held for the export record id once the request is done,
+// the stored export is then counted from the exports and a failed save gives back its usage
+func (p *PocketExport) releaseExportOnDone(ctx context.Context, recordId string) {
+	done := ctx.Done()
+	if done == nil {
+		return
+	}
+
+	go func() {
+		<-done
+		p.usage.release(recordId)
+	}()
+}
+
+// requestOwnerKey returns the usage key of the requesting auth record, empty for the admins and the guests
+func requestOwnerKey(c echo.Context) string {
+	info := apis.RequestInfo(c)
+	if info.AuthRecord == nil {
+		return ""
+	}
+
+	return info.AuthRecord.Collection().Name + "/" + info.AuthRecord.Id
+}
+
+// usageHandler returns the export usage and the remaining quotas of the requesting auth record,
+// the admins have no limit
+func (p *PocketExport) usageHandler(c echo.Context) error {
+	key := requestOwnerKey(c)
+	now := time.Now()
+	resetsAt, _ := types.ParseDateTime(usageDayStart(now).AddDate(0, 0, 1))
+
+	rc := p.config
+	counts := usageCounts{}
+	if key == "" {
+		rc = registerConfig{}
+	} else {
+		p.usage.mu.Lock()
+		defer p.usage.mu.Unlock()
+
+		var err error
+		if counts, err = p.countUsage(key, now); err != nil {
+			return apis.NewBadRequestError("Failed to count the usage.", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, &ExportUsage{
+		Minute:      newUsageItem(counts.Minute, int64(rc.ownerRateLimit.PerMinute)),
+		Hour:        newUsageItem(counts.Hour, int64(rc.ownerRateLimit.PerHour)),
+		Rows:        newUsageItem(counts.Rows, int64(rc.dailyRowQuota)),
+		OutputBytes: newUsageItem(counts.Bytes, rc.dailyOutputQuota),
+		ResetsAt:    resetsAt,
+	})
+}
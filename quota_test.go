@@ -0,0 +1,247 @@
+package pocketexport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// saveUsageExport stores an export record of the user for the usage
+func saveUsageExport(t *testing.T, app *tests.TestApp, status string, rowCount int, outputSize int, duplicateOf string) *models.Record {
+	record := getExportRecord(t, app)
+	record.Id = ""
+	record.RefreshId()
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(StatusField, status)
+	record.Set(RowCountField, rowCount)
+	record.Set(OutputSizeField, outputSize)
+	record.Set(DuplicateOfField, duplicateOf)
+	if err := app.Dao().WithoutHooks().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
+
+func Test_pocketExport_CountStoredUsage(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	original := saveUsageExport(t, testApp, StatusSuccess, 2, 100, "")
+	// the failed exports and the duplicates are not charged
+	saveUsageExport(t, testApp, StatusFailed, 3, 0, "")
+	saveUsageExport(t, testApp, StatusSuccess, 2, 100, original.Id)
+
+	// the streams are charged from their audit
+	exportService.auditExport(nil, AuditActionStream, original, "")
+	audit, err := testApp.Dao().FindFirstRecordByData(PocketExportAuditCollectionName, ActionField, AuditActionStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Set(ActorIdField, "vzz4enej24xtni9")
+	audit.Set(ActorCollectionNameField, "users")
+	if err := testApp.Dao().WithoutHooks().SaveRecord(audit); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	dayEnd := usageDayStart(now).AddDate(0, 0, 1)
+	if dayEnd.Sub(now) < 3*time.Minute {
+		t.Skip("the scenarios run over midnight")
+	}
+	scenarios := []struct {
+		key    string
+		now    time.Time
+		expect usageCounts
+	}{
+		{"users/vzz4enej24xtni9", now, usageCounts{Minute: 2, Hour: 2, Rows: 4, Bytes: 100}},
+		{"", now, usageCounts{Minute: 2, Hour: 2, Rows: 4, Bytes: 100}},
+		{"users/djh54wc2hpkhfkw", now, usageCounts{}},
+		// the minute and the hour are over
+		{"users/vzz4enej24xtni9", now.Add(2 * time.Minute), usageCounts{Hour: 2, Rows: 4, Bytes: 100}},
+		// the day is over
+		{"users/vzz4enej24xtni9", dayEnd.Add(time.Hour + time.Second), usageCounts{}},
+	}
+
+	for i, s := range scenarios {
+		counts, err := exportService.countStoredUsage(s.key, s.now)
+		if err != nil {
+			t.Fatalf("(%d) unexpected error %v", i, err)
+		} else if counts != s.expect {
+			t.Fatalf("(%d) expect %+v, got %+v", i, s.expect, counts)
+		}
+	}
+}
+
+func Test_pocketExport_ReserveExport(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	OwnerRateLimit(1, 0)(&exportService.config)
+	DailyRowQuota(5)(&exportService.config)
+
+	record := getExportRecord(t, testApp)
+	record.Id = ""
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	record.Set(RowCountField, 2)
+
+	if err := exportService.reserveExport(record); err != nil {
+		t.Fatal(err)
+	}
+
+	// the held export counts until it is released
+	other := getExportRecord(t, testApp)
+	other.Id = ""
+	other.Set(OwnerIdField, "vzz4enej24xtni9")
+	other.Set(OwnerCollectionNameField, "users")
+	other.Set(RowCountField, 2)
+	if err := exportService.reserveExport(other); err == nil {
+		t.Fatal("should reach the rate limit")
+	} else if apiErr, ok := err.(*apis.ApiError); !ok || apiErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expect too many requests, got %v", err)
+	}
+
+	// a failed save gives back the usage when the request is done
+	ctx, cancel := context.WithCancel(context.Background())
+	exportService.releaseExportOnDone(ctx, record.Id)
+	cancel()
+	for i := 0; i < 100 && len(exportService.usage.holds) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := exportService.checkExportRateLimits("users/vzz4enej24xtni9"); err != nil {
+		t.Fatalf("expect the usage to be released, got %v", err)
+	}
+
+	// the stored exports are charged
+	saveUsageExport(t, testApp, StatusPending, 4, 0, "")
+	if err := exportService.checkExportRateLimits("users/vzz4enej24xtni9"); err == nil {
+		t.Fatal("should reach the rate limit")
+	}
+
+	OwnerRateLimit(0, 0)(&exportService.config)
+	if err := exportService.reserveExport(other); err == nil {
+		t.Fatal("should reach the daily row quota")
+	}
+
+	// the admins have no limit
+	record.Set(OwnerCollectionNameField, "")
+	if err := exportService.reserveExport(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_QuotaApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	adminToken := getAdminToken(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "daily row quota",
+			Method: http.MethodPost,
+			Url:    "/api/collections/" + PocketExportCollectionName + "/records",
+			Body: strings.NewReader(`{
+				"exportCollectionName": "messages",
+				"headers": [{"fieldName": "message", "header": "nội dung"}],
+				"format": "csv",
+				"ownerId": "vzz4enej24xtni9",
+				"ownerCollectionName": "users"
+			}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"message":"The export exceeds your daily row quota."`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(DailyRowQuota(1)),
+		},
+		{
+			Name:   "stream daily row quota",
+			Method: http.MethodPost,
+			Url:    "/api/pocketexport/stream",
+			Body: strings.NewReader(`{
+				"exportCollectionName": "messages",
+				"headers": [{"fieldName": "message", "header": "nội dung"}],
+				"format": "csv"
+			}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"message":"The export exceeds your daily row quota."`},
+			TestAppFactory:  newRegisteredTestApp(DailyRowQuota(1)),
+		},
+		{
+			Name:   "rate limit before the validation",
+			Method: http.MethodPost,
+			Url:    "/api/pocketexport/stream",
+			Body: strings.NewReader(`{
+				"exportCollectionName": "unknown",
+				"format": "csv"
+			}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"message":"You have created too many exports, try again later."`},
+			BeforeTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				saveUsageExport(t, app, StatusSuccess, 1, 10, "")
+			},
+			TestAppFactory: newRegisteredTestApp(OwnerRateLimit(1, 0)),
+		},
+		{
+			Name:           "stored usage",
+			Method:         http.MethodGet,
+			Url:            "/api/pocketexport/usage",
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"minute":{"used":1,"limit":5,"remaining":4}`,
+				`"rows":{"used":1,"limit":0,"remaining":-1}`,
+				`"outputBytes":{"used":10,"limit":0,"remaining":-1}`,
+			},
+			BeforeTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				saveUsageExport(t, app, StatusSuccess, 1, 10, "")
+			},
+			TestAppFactory: newRegisteredTestApp(OwnerRateLimit(5, 0)),
+		},
+		{
+			Name:           "user usage",
+			Method:         http.MethodGet,
+			Url:            "/api/pocketexport/usage",
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"minute":{"used":0,"limit":5,"remaining":5}`,
+				`"rows":{"used":0,"limit":1,"remaining":1}`,
+				`"outputBytes":{"used":0,"limit":0,"remaining":-1}`,
+			},
+			TestAppFactory: newRegisteredTestApp(OwnerRateLimit(5, 0), DailyRowQuota(1)),
+		},
+		{
+			Name:            "admin usage",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/usage",
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"rows":{"used":0,"limit":0,"remaining":-1}`},
+			TestAppFactory:  newRegisteredTestApp(DailyRowQuota(1)),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,85 @@
+package pocketexport
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/resolvers"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+var (
+	errAdminOnlyCollection = validation.NewError("validation_admin_only_collection", "only admins can export the collection")
+	errInvalidExportRule   = validation.NewError("validation_invalid_export_rule", "invalid export rule")
+)
+
+// collectionExportRule returns the export rule of the collection settings,
+// nil when the settings lock the export to the admins and
+// the collection list rule if the collection has no export rule.
+// A nil rule means that only admins can export the collection.
+func (p *PocketExport) collectionExportRule(collection *models.Collection) (*string, error) {
+	settings, err := p.app.Dao().FindFirstRecordByData(PocketExportSettingsCollectionName, CollectionNameField, collection.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if settings != nil {
+		if settings.GetBool(AdminOnlyField) {
+			return nil, nil
+		}
+
+		if rule := settings.GetString(ExportRuleField); rule != "" {
+			return &rule, nil
+		}
+	}
+
+	return collection.ListRule, nil
+}
+
+// validateSettings validates the configured collection and the export rule of the settings record
+func (p *PocketExport) validateSettings(r *models.Record) error {
+	collection, err := p.app.Dao().FindCollectionByNameOrId(r.GetString(CollectionNameField))
+	if err != nil || collection.Name != r.GetString(CollectionNameField) {
+		return validation.Errors{CollectionNameField: validation.NewError("validation_invalid_collection", "invalid collection")}
+	}
+
+	rule := r.GetString(ExportRuleField)
+	if rule == "" {
+		return nil
+	}
+
+	fieldResolver := resolvers.NewRecordFieldResolver(
+		p.app.Dao(),
+		collection,
+		&models.RequestInfo{Method: http.MethodGet},
+		false,
+	)
+	if _, err := search.FilterData(rule).BuildExpr(fieldResolver); err != nil {
+		return validation.Errors{ExportRuleField: errInvalidExportRule}
+	}
+
+	return nil
+}
+
+// bindSettings validates the collection export settings records
+func (p *PocketExport) bindSettings() {
+	p.app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		if e.Record.TableName() != PocketExportSettingsCollectionName {
+			return nil
+		}
+
+		return p.validateSettings(e.Record)
+	})
+
+	p.app.OnRecordBeforeUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		if e.Record.TableName() != PocketExportSettingsCollectionName {
+			return nil
+		}
+
+		return p.validateSettings(e.Record)
+	})
+}
@@ -0,0 +1,170 @@
+package pocketexport
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func Test_pocketExport_CollectionExportRule(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	messages, err := testApp.Dao().FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// falls back to the list rule
+	if rule, err := exportService.collectionExportRule(messages); err != nil {
+		t.Fatal(err)
+	} else if rule == nil || *rule != *messages.ListRule {
+		t.Fatal("should use the list rule")
+	}
+
+	// admin only collection
+	messages.ListRule = nil
+	if err := testApp.Dao().SaveCollection(messages); err != nil {
+		t.Fatal(err)
+	}
+
+	record := getExportRecord(t, testApp)
+	if _, err := exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+
+	record.Set(OwnerIdField, "vzz4enej24xtni9")
+	record.Set(OwnerCollectionNameField, "users")
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[ExportCollectionNameField].(validation.Error).Code() != "validation_admin_only_collection" {
+		t.Fatal(err)
+	}
+
+	export := NewExport(record)
+	if err := export.Fill(testApp.Dao()); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportService.GenerateExportOutput(bytes.NewBuffer(nil), export); err == nil {
+		t.Fatal("should not generate the admin only collection")
+	}
+
+	// export rule
+	settingsCollection, err := testApp.Dao().FindCollectionByNameOrId(PocketExportSettingsCollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := models.NewRecord(settingsCollection)
+	settings.Set(CollectionNameField, "messages")
+	settings.Set(ExportRuleField, "author = @request.auth.id")
+	if err := testApp.Dao().SaveRecord(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	} else if record.GetInt(RowCountField) != 1 {
+		t.Fatalf("expect row count 1, got %d", record.GetInt(RowCountField))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "nội dung\ntest1\n" {
+		t.Fatal(buf.String())
+	}
+
+	// admin only export of a public collection
+	messages.ListRule = types.Pointer("")
+	if err := testApp.Dao().SaveCollection(messages); err != nil {
+		t.Fatal(err)
+	}
+	settings.Set(ExportRuleField, "")
+	settings.Set(AdminOnlyField, true)
+	if err := testApp.Dao().SaveRecord(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule, err := exportService.collectionExportRule(messages); err != nil {
+		t.Fatal(err)
+	} else if rule != nil {
+		t.Fatal("should lock the export to the admins")
+	}
+
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should have error")
+	} else if err.(validation.Errors)[ExportCollectionNameField].(validation.Error).Code() != "validation_admin_only_collection" {
+		t.Fatal(err)
+	}
+
+	record.Set(OwnerIdField, "x9fs8mten7zmwcv")
+	record.Set(OwnerCollectionNameField, "")
+	if _, err := exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pocketExport_SettingsApi(t *testing.T) {
+	adminToken := getAdminToken(t)
+	errorEvents := map[string]int{
+		"OnRecordBeforeCreateRequest": 1,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "invalid collection",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportSettingsCollectionName + "/records",
+			Body:            strings.NewReader(`{"collectionName": "missing"}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"collectionName":{"code":"validation_invalid_collection"`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:            "invalid export rule",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportSettingsCollectionName + "/records",
+			Body:            strings.NewReader(`{"collectionName": "messages", "exportRule": "missing = 1"}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"exportRule":{"code":"validation_invalid_export_rule"`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(),
+		},
+		{
+			Name:            "valid",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportSettingsCollectionName + "/records",
+			Body:            strings.NewReader(`{"collectionName": "messages", "exportRule": "author = @request.auth.id"}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"exportRule":"author = @request.auth.id"`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+				"OnRecordAfterCreateRequest":  1,
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
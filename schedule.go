@@ -0,0 +1,371 @@
+package pocketexport
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// scheduleMissedAfter is how late a due schedule can be picked up
+// before it is considered a missed run, eg. after a downtime
+const scheduleMissedAfter = 2 * time.Minute
+
+// scheduleSearchLimit is how far the next run of a cron expression is searched
+const scheduleSearchLimit = 5 * 366 * 24 * time.Hour
+
+var (
+	errInvalidCron    = validation.NewError("validation_invalid_cron", "invalid cron expression")
+	errCronNeverRuns  = validation.NewError("validation_cron_never_runs", "cron expression never runs")
+	errInvalidCatchUp = validation.NewError("validation_invalid_catch_up", "invalid catch up policy")
+
+	ErrIsNotSchedule = validation.NewError("validation_is_not_schedule", "is not schedule")
+)
+
+// scheduleNextRun returns the first minute after t that satisfies the cron schedule in loc.
+func scheduleNextRun(schedule *cron.Schedule, loc *time.Location, after time.Time) (time.Time, error) {
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleSearchLimit)
+
+	for t.Before(limit) {
+		moment := cron.NewMoment(t)
+		_, month := schedule.Months[moment.Month]
+		_, day := schedule.Days[moment.Day]
+		_, dayOfWeek := schedule.DaysOfWeek[moment.DayOfWeek]
+		if !month || !day || !dayOfWeek {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if _, hour := schedule.Hours[moment.Hour]; !hour {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if schedule.IsDue(moment) {
+			return t.UTC(), nil
+		}
+
+		t = t.Add(time.Minute)
+	}
+
+	return time.Time{}, errCronNeverRuns
+}
+
+// scheduleLocation returns the location of the schedule timezone, UTC by default
+func scheduleLocation(record *models.Record) (*time.Location, error) {
+	if timezone := record.GetString(TimezoneField); timezone != "" {
+		return time.LoadLocation(timezone)
+	}
+
+	return time.UTC, nil
+}
+
+// newScheduleExportRecord creates an unsaved export record from the schedule template
+func (p *PocketExport) newScheduleExportRecord(schedule *models.Record) (*models.Record, error) {
+	collection, err := p.app.Dao().FindCollectionByNameOrId(PocketExportCollectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewRecord(collection)
+	for _, field := range []string{
+		ExportCollectionNameField,
+		HeadersField,
+		FilterField,
+		SortField,
+		FormatField,
+		OwnerIdField,
+		OwnerCollectionNameField,
+		NotifyEmailField,
+		WebhookUrlField,
+		DestinationField,
+		DestinationPathField,
+		DefaultTimezoneField,
+		LocaleField,
+		UnwindField,
+		UnwindKeepEmptyField,
+	} {
+		record.Set(field, schedule.Get(field))
+	}
+
+	record.Set(ScheduleIdField, schedule.Id)
+	record.Set(StatusField, StatusPending)
+	record.Set(OutputField, exportOutputFilename(record.GetString(FormatField)))
+
+	return record, nil
+}
+
+// validateSchedule validates the schedule record and fills its next run,
+// the export template is validated like a regular export
+func (p *PocketExport) validateSchedule(r *models.Record) error {
+	if r.TableName() != PocketExportScheduleCollectionName {
+		return ErrIsNotSchedule
+	}
+
+	schedule, err := cron.NewSchedule(r.GetString(CronField))
+	if err != nil {
+		return validation.Errors{CronField: errInvalidCron}
+	}
+
+	loc, err := scheduleLocation(r)
+	if err != nil {
+		return validation.Errors{TimezoneField: err}
+	}
+
+	switch r.GetString(CatchUpField) {
+	case "", CatchUpOnce, CatchUpSkip:
+	default:
+		return validation.Errors{CatchUpField: errInvalidCatchUp}
+	}
+
+	exportRecord, err := p.newScheduleExportRecord(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.ValidateAndFill(exportRecord); err != nil {
+		return err
+	}
+
+	nextRunAt, err := scheduleNextRun(schedule, loc, time.Now())
+	if err != nil {
+		return validation.Errors{CronField: err}
+	}
+
+	r.Set(NextRunAtField, nextRunAt)
+	return nil
+}
+
+// runSchedule creates an export record from the schedule through the regular
+// create path, generates it and returns it.
+func (p *PocketExport) runSchedule(schedule *models.Record) (*models.Record, error) {
+	record, err := p.newScheduleExportRecord(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &core.RecordCreateEvent{Record: record, UploadedFiles: map[string][]*filesystem.File{}}
+	if err := p.prepareExport(e, true); err != nil {
+		return nil, err
+	}
+	record.Set(ScheduleIdField, schedule.Id)
+
+	if err := p.app.Dao().SaveRecord(record); err != nil {
+		p.releaseExport(record)
+		return nil, err
+	}
+	p.auditExport(nil, AuditActionCreate, record, "")
+
+	if record.GetString(DuplicateOfField) != "" {
+		if err := p.runScheduleDuplicate(e); err != nil {
+			return record, err
+		}
+	} else if err := p.processExport(record.Id); err != nil {
+		return record, err
+	}
+
+	return record, p.applyScheduleRetention(schedule)
+}
+
+// runScheduleDuplicate stores the output reused by the scheduled duplicate export
+// and notifies it, a pending duplicate completes with its original export
+func (p *PocketExport) runScheduleDuplicate(e *core.RecordCreateEvent) error {
+	if e.Record.GetString(StatusField) == StatusPending {
+		return nil
+	}
+
+	if files := e.UploadedFiles[OutputField]; len(files) > 0 {
+		fs, err := p.app.NewFilesystem()
+		if err != nil {
+			return fmt.Errorf("get filesystem failed: %w", err)
+		}
+		defer fs.Close()
+
+		if err := fs.UploadFile(files[0], e.Record.BaseFilesPath()+"/"+files[0].Name); err != nil {
+			return fmt.Errorf("upload file failed: %w", err)
+		}
+	}
+
+	if err := p.notifyExportEmail(e.Record); err != nil {
+		log.Printf("pocketexport: notify email failed: %v", err)
+	}
+	p.fireExportWebhooks(e.Record)
+
+	return nil
+}
+
+// applyScheduleRetention deletes the exports of the schedule
+// except the latest retention and the pinned ones, 0 keeps all exports
+func (p *PocketExport) applyScheduleRetention(schedule *models.Record) error {
+	retention := schedule.GetInt(RetentionField)
+	if retention <= 0 {
+		return nil
+	}
+
+	dao := p.app.Dao()
+	collection, err := dao.FindCollectionByNameOrId(PocketExportCollectionName)
+	if err != nil {
+		return err
+	}
+
+	records := []*models.Record{}
+	if err := dao.RecordQuery(collection).
+		AndWhere(dbx.HashExp{ScheduleIdField: schedule.Id}).
+		OrderBy("created DESC").
+		All(&records); err != nil {
+		return err
+	}
+
+	kept := 0
+	for _, r := range records {
+		if r.GetBool(PinnedField) {
+			continue
+		}
+
+		if kept < retention {
+			kept++
+			continue
+		}
+
+		if err := dao.DeleteRecord(r); err != nil {
+			return err
+		}
+		p.auditExport(nil, AuditActionDelete, r, "")
+	}
+
+	return nil
+}
+
+// runDueSchedules runs the schedules that are due at now, missed runs
+// are run once or skipped depending on the schedule catch up policy.
+func (p *PocketExport) runDueSchedules(now time.Time) error {
+	// skip overlapping ticks while the previous runs are still generating
+	if !p.scheduleMu.TryLock() {
+		return nil
+	}
+	defer p.scheduleMu.Unlock()
+
+	dao := p.app.Dao()
+	schedules, err := dao.FindRecordsByExpr(
+		PocketExportScheduleCollectionName,
+		dbx.NewExp(
+			"[["+PocketExportScheduleCollectionName+"."+NextRunAtField+"]] != '' AND "+
+				"[["+PocketExportScheduleCollectionName+"."+NextRunAtField+"]] <= {:now}",
+			dbx.Params{"now": now.UTC().Format(types.DefaultDateLayout)},
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if err := p.runDueSchedule(schedule, now); err != nil {
+			log.Printf("pocketexport: run schedule %s failed: %v", schedule.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// runDueSchedule runs a single due schedule and updates its bookkeeping.
+func (p *PocketExport) runDueSchedule(schedule *models.Record, now time.Time) error {
+	cronSchedule, err := cron.NewSchedule(schedule.GetString(CronField))
+	if err != nil {
+		return err
+	}
+
+	loc, err := scheduleLocation(schedule)
+	if err != nil {
+		return err
+	}
+
+	missed := now.Sub(schedule.GetDateTime(NextRunAtField).Time()) > scheduleMissedAfter
+
+	// move the next run first so that a failing export is not retried every tick
+	nextRunAt, err := scheduleNextRun(cronSchedule, loc, now)
+	if err != nil {
+		return err
+	}
+	schedule.Set(NextRunAtField, nextRunAt)
+
+	if missed && schedule.GetString(CatchUpField) == CatchUpSkip {
+		return p.app.Dao().SaveRecord(schedule)
+	}
+
+	schedule.Set(LastRunAtField, now.UTC())
+	if err := p.app.Dao().SaveRecord(schedule); err != nil {
+		return err
+	}
+
+	record, runErr := p.runSchedule(schedule)
+	if record != nil {
+		schedule.Set(LastExportIdField, record.Id)
+	}
+
+	schedule.Set(LastErrorField, "")
+	if runErr != nil {
+		schedule.Set(LastErrorField, runErr.Error())
+	}
+
+	if err := p.app.Dao().SaveRecord(schedule); err != nil {
+		return err
+	}
+
+	return runErr
+}
+
+// bindSchedules validates the schedule records and
+// starts the scheduler when the app starts serving.
+func (p *PocketExport) bindSchedules() {
+	validate := func(r *models.Record) error {
+		if r.TableName() != PocketExportScheduleCollectionName {
+			return nil
+		}
+
+		// bookkeeping fields are managed by the scheduler
+		original := r.OriginalCopy()
+		for _, field := range []string{LastRunAtField, LastExportIdField, LastErrorField} {
+			if r.IsNew() {
+				r.Set(field, nil)
+			} else {
+				r.Set(field, original.Get(field))
+			}
+		}
+
+		return p.validateSchedule(r)
+	}
+
+	p.app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		return validate(e.Record)
+	})
+
+	p.app.OnRecordBeforeUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		return validate(e.Record)
+	})
+
+	scheduler := cron.New()
+	scheduler.MustAdd(PocketExportScheduleCollectionName, "* * * * *", func() {
+		if err := p.runDueSchedules(time.Now()); err != nil {
+			log.Printf("pocketexport: run schedules failed: %v", err)
+		}
+	})
+
+	// the runs missed while the app was down are caught up on the first tick
+	p.app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		scheduler.Start()
+		return nil
+	})
+
+	p.app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		scheduler.Stop()
+		return nil
+	})
+}
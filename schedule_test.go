@@ -0,0 +1,308 @@
+package pocketexport
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/cron"
+)
+
+func getScheduleRecord(t *testing.T, app core.App) *models.Record {
+	coll, err := app.Dao().FindCollectionByNameOrId(PocketExportScheduleCollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := getExportRecord(t, app)
+	record := models.NewRecord(coll)
+	for _, field := range []string{
+		ExportCollectionNameField,
+		HeadersField,
+		FilterField,
+		SortField,
+		FormatField,
+		OwnerIdField,
+		OwnerCollectionNameField,
+	} {
+		record.Set(field, export.Get(field))
+	}
+	record.Set(CronField, "* * * * *")
+
+	return record
+}
+
+func findScheduleExports(t *testing.T, app core.App, scheduleId string) []*models.Record {
+	records, err := app.Dao().FindRecordsByExpr(
+		PocketExportCollectionName,
+		dbx.HashExp{ScheduleIdField: scheduleId},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func Test_scheduleNextRun(t *testing.T) {
+	location, err := time.LoadLocation("Asia/Ho_Chi_Minh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule, err := cron.NewSchedule("0 7 * * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday 2026-10-18 10:00 UTC
+	after := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	next, err := scheduleNextRun(schedule, location, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := time.Date(2026, 10, 19, 7, 0, 0, 0, location)
+	if !next.Equal(expect) {
+		t.Fatalf("expect %v, got %v", expect, next)
+	}
+
+	// no 31st of February
+	schedule, err = cron.NewSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scheduleNextRun(schedule, time.UTC, after); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func Test_pocketExport_RunDueSchedules(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	now := time.Now()
+
+	due := getScheduleRecord(t, testApp)
+	due.Set(NextRunAtField, now.Add(-30*time.Second))
+	due.Set(RetentionField, 1)
+	if err := testApp.Dao().SaveRecord(due); err != nil {
+		t.Fatal(err)
+	}
+
+	missed := getScheduleRecord(t, testApp)
+	missed.Set(NextRunAtField, now.Add(-time.Hour))
+	missed.Set(CatchUpField, CatchUpSkip)
+	if err := testApp.Dao().SaveRecord(missed); err != nil {
+		t.Fatal(err)
+	}
+
+	notDue := getScheduleRecord(t, testApp)
+	notDue.Set(NextRunAtField, now.Add(time.Hour))
+	if err := testApp.Dao().SaveRecord(notDue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.runDueSchedules(now); err != nil {
+		t.Fatal(err)
+	}
+
+	exports := findScheduleExports(t, testApp, due.Id)
+	if len(exports) != 1 {
+		t.Fatalf("expect 1 export, got %d", len(exports))
+	} else if exports[0].GetString(OutputField) == "" {
+		t.Fatal("should have output")
+	}
+
+	due, _ = testApp.Dao().FindRecordById(PocketExportScheduleCollectionName, due.Id)
+	if due.GetString(LastExportIdField) != exports[0].Id {
+		t.Fatal("should have last export id")
+	} else if due.GetString(LastErrorField) != "" {
+		t.Fatal(due.GetString(LastErrorField))
+	} else if due.GetDateTime(LastRunAtField).IsZero() {
+		t.Fatal("should have last run")
+	} else if !due.GetDateTime(NextRunAtField).Time().After(now) {
+		t.Fatal("next run should be in the future")
+	}
+
+	if len(findScheduleExports(t, testApp, missed.Id)) != 0 {
+		t.Fatal("missed schedule should be skipped")
+	}
+	missed, _ = testApp.Dao().FindRecordById(PocketExportScheduleCollectionName, missed.Id)
+	if !missed.GetDateTime(NextRunAtField).Time().After(now) {
+		t.Fatal("next run should be in the future")
+	}
+
+	if len(findScheduleExports(t, testApp, notDue.Id)) != 0 {
+		t.Fatal("schedule should not be due")
+	}
+
+	// retention keeps the latest export only
+	due.Set(NextRunAtField, now.Add(-30*time.Second))
+	if err := testApp.Dao().SaveRecord(due); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportService.runDueSchedules(now); err != nil {
+		t.Fatal(err)
+	}
+
+	exports = findScheduleExports(t, testApp, due.Id)
+	if len(exports) != 1 {
+		t.Fatalf("expect 1 export, got %d", len(exports))
+	}
+	due, _ = testApp.Dao().FindRecordById(PocketExportScheduleCollectionName, due.Id)
+	if due.GetString(LastExportIdField) != exports[0].Id {
+		t.Fatal("should keep the last export")
+	}
+}
+
+func Test_pocketExport_RunSchedule(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	exportService := New(testApp)
+	DedupeWindow(time.Minute)(&exportService.config)
+
+	// the cron timezone is not the default timezone of the headers
+	schedule := getScheduleRecord(t, testApp)
+	schedule.Set(TimezoneField, "Asia/Ho_Chi_Minh")
+	record, err := exportService.newScheduleExportRecord(schedule)
+	if err != nil {
+		t.Fatal(err)
+	} else if record.GetString(DefaultTimezoneField) != "" {
+		t.Fatal("should not use the cron timezone")
+	}
+
+	schedule.Set(DefaultTimezoneField, "Europe/Berlin")
+	if record, err = exportService.newScheduleExportRecord(schedule); err != nil {
+		t.Fatal(err)
+	} else if record.GetString(DefaultTimezoneField) != "Europe/Berlin" {
+		t.Fatal("should copy the default timezone")
+	}
+
+	if err := testApp.Dao().SaveRecord(schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := exportService.runSchedule(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second run reuses the output of the first one
+	second, err := exportService.runSchedule(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, second.Id)
+	if second.GetString(DuplicateOfField) != first.Id || second.GetString(StatusField) != StatusSuccess {
+		t.Fatal("should dedupe the scheduled export")
+	} else if second.GetString(ScheduleIdField) != schedule.Id {
+		t.Fatal("should keep the schedule id")
+	}
+
+	fs, err := testApp.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if exists, err := fs.Exists(second.BaseFilesPath() + "/" + second.GetString(OutputField)); err != nil || !exists {
+		t.Fatal("should store the reused output")
+	}
+
+	// the quotas of the owner apply to the scheduled runs
+	DailyRowQuota(1)(&exportService.config)
+	schedule.Set(OwnerIdField, "vzz4enej24xtni9")
+	schedule.Set(OwnerCollectionNameField, "users")
+	schedule.Set(FilterField, `id != ""`)
+	if _, err := exportService.runSchedule(schedule); err == nil {
+		t.Fatal("should exceed the daily row quota")
+	} else if apiErr, ok := err.(*apis.ApiError); !ok || apiErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expect too many requests, got %v", err)
+	}
+}
+
+func Test_pocketExport_SchedulesApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	body := func(cron string) *strings.Reader {
+		return strings.NewReader(`{
+			"exportCollectionName": "messages",
+			"headers": [{"fieldName": "message", "header": "nội dung"}],
+			"format": "csv",
+			"ownerId": "vzz4enej24xtni9",
+			"ownerCollectionName": "users",
+			"cron": "` + cron + `",
+			"timezone": "Asia/Ho_Chi_Minh",
+			"lastExportId": "fake"
+		}`)
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "invalid cron",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportScheduleCollectionName + "/records",
+			Body:            body("* * *"),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"cron":{"code":"validation_invalid_cron"`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+		},
+		{
+			Name:           "valid",
+			Method:         http.MethodPost,
+			Url:            "/api/collections/" + PocketExportScheduleCollectionName + "/records",
+			Body:           body("0 7 * * 1"),
+			RequestHeaders: map[string]string{"Authorization": userToken},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"cron":"0 7 * * 1"`,
+				`"lastExportId":""`,
+			},
+			NotExpectedContent: []string{`"nextRunAt":""`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+				"OnRecordAfterCreateRequest":  1,
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+			},
+			TestAppFactory: newRegisteredTestApp(),
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				records, err := app.Dao().FindRecordsByExpr(PocketExportScheduleCollectionName)
+				if err != nil || len(records) != 1 {
+					t.Fatal("should create the schedule")
+				}
+
+				location, _ := time.LoadLocation("Asia/Ho_Chi_Minh")
+				next := records[0].GetDateTime(NextRunAtField).Time().In(location)
+				if next.Weekday() != time.Monday || next.Hour() != 7 || next.Minute() != 0 {
+					t.Fatalf("wrong next run %v", next)
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
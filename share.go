@@ -0,0 +1,166 @@
+package pocketexport
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// shareTokenLength is the length of the public share tokens
+const shareTokenLength = 40
+
+var (
+	errInvalidRecipient     = validation.NewError("validation_invalid_recipient", "invalid recipient")
+	errPublicShareRecipient = validation.NewError("validation_public_share_recipient", "a public share has no recipient")
+	errShareReadOnlyField   = validation.NewError("validation_read_only", "only the revoked and expiresAt fields can be updated")
+
+	ErrIsNotShare = validation.NewError("validation_is_not_share", "is not share")
+)
+
+// isShareActive checks whether the share is not revoked nor expired at now
+func isShareActive(share *models.Record, now time.Time) bool {
+	if share.GetBool(RevokedField) {
+		return false
+	}
+
+	expiresAt := share.GetDateTime(ExpiresAtField)
+	return expiresAt.IsZero() || expiresAt.Time().After(now)
+}
+
+// validateShare validates the recipient of the share record,
+// a public share gets a new token and has no recipient, the other shares are
+// for a record of an auth collection or for all the records of the auth collection.
+func (p *PocketExport) validateShare(r *models.Record) error {
+	if r.TableName() != PocketExportShareCollectionName {
+		return ErrIsNotShare
+	}
+
+	recipientId := r.GetString(RecipientIdField)
+	recipientCollectionName := r.GetString(RecipientCollectionNameField)
+
+	if r.GetBool(PublicField) {
+		if recipientId != "" || recipientCollectionName != "" {
+			return validation.Errors{PublicField: errPublicShareRecipient}
+		}
+
+		r.Set(TokenField, security.RandomString(shareTokenLength))
+		return nil
+	}
+
+	r.Set(TokenField, "")
+
+	collection, err := p.app.Dao().FindCollectionByNameOrId(recipientCollectionName)
+	if err != nil || !collection.IsAuth() || collection.Name != recipientCollectionName {
+		return validation.Errors{RecipientCollectionNameField: errInvalidRecipient}
+	}
+
+	if recipientId == "" {
+		return nil
+	}
+
+	if _, err := p.app.Dao().FindRecordById(collection.Id, recipientId); err != nil {
+		return validation.Errors{RecipientIdField: errInvalidRecipient}
+	}
+
+	return nil
+}
+
+// isExportSharedWith checks whether the export record has an active share for the auth record
+func (p *PocketExport) isExportSharedWith(record *models.Record, authRecord *models.Record) (bool, error) {
+	if authRecord == nil {
+		return false, nil
+	}
+
+	shares, err := p.app.Dao().FindRecordsByExpr(
+		PocketExportShareCollectionName,
+		dbx.HashExp{
+			ShareExportField:             record.Id,
+			RecipientCollectionNameField: authRecord.Collection().Name,
+			PublicField:                  false,
+		},
+		dbx.In(RecipientIdField, "", authRecord.Id),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, share := range shares {
+		if isShareActive(share, now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// sharedHandler serves the export output to the holders of an active public share token.
+func (p *PocketExport) sharedHandler(c echo.Context) error {
+	token := c.PathParam("token")
+	share, err := p.app.Dao().FindFirstRecordByData(PocketExportShareCollectionName, TokenField, token)
+	if err != nil || token == "" || !share.GetBool(PublicField) || !isShareActive(share, time.Now()) {
+		return apis.NewForbiddenError("The share link is invalid, revoked or has expired.", nil)
+	}
+
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, share.GetString(ShareExportField))
+	if err != nil {
+		return apis.NewNotFoundError("", err)
+	}
+
+	switch record.GetString(StatusField) {
+	case StatusPending:
+		return apis.NewBadRequestError("The export output is not generated yet.", nil)
+	case StatusFailed:
+		return apis.NewBadRequestError("The export generation failed.", nil)
+	}
+
+	if record.GetString(OutputField) == "" {
+		return apis.NewNotFoundError("The export has no output.", nil)
+	}
+
+	if record, err = p.recordExportDownload(record.Id, ""); err != nil {
+		return apis.NewBadRequestError("Failed to record the download.", err)
+	}
+	p.auditExport(c, AuditActionDownload, record, share.Id)
+
+	return p.serveExportOutput(c, record)
+}
+
+// bindShares validates the share records, only the revoked
+// and expiresAt fields of a share can be updated.
+func (p *PocketExport) bindShares() {
+	p.app.OnRecordBeforeCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		if e.Record.TableName() != PocketExportShareCollectionName {
+			return nil
+		}
+
+		return p.validateShare(e.Record)
+	})
+
+	p.app.OnRecordBeforeUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		if e.Record.TableName() != PocketExportShareCollectionName {
+			return nil
+		}
+
+		original := e.Record.OriginalCopy()
+		for _, field := range []string{
+			ShareExportField,
+			RecipientIdField,
+			RecipientCollectionNameField,
+			PublicField,
+			TokenField,
+		} {
+			if e.Record.GetString(field) != original.GetString(field) {
+				return validation.Errors{field: errShareReadOnlyField}
+			}
+		}
+
+		return nil
+	})
+}
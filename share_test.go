@@ -0,0 +1,486 @@
+package pocketexport
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// saveShareRecord saves the test export with its output and a share with the data
+func saveShareRecord(t *testing.T, app *tests.TestApp, data map[string]any) *models.Record {
+	record := getExportRecord(t, app)
+	record.Set(StatusField, StatusSuccess)
+	record.Set(OutputField, exportOutputFilename(FormatCSV))
+	if err := app.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(app).uploadExportOutput(record); err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := app.Dao().FindCollectionByNameOrId(PocketExportShareCollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	share := models.NewRecord(collection)
+	share.Set(ShareExportField, record.Id)
+	share.Load(data)
+	if err := app.Dao().SaveRecord(share); err != nil {
+		t.Fatal(err)
+	}
+
+	return share
+}
+
+func Test_pocketExport_ShareViewApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	otherToken := getUserToken(t, "djh54wc2hpkhfkw")
+	beforeTestFunc := func(data map[string]any) func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		return func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+			saveShareRecord(t, app, data)
+		}
+	}
+	recipient := map[string]any{
+		RecipientIdField:             "vzz4enej24xtni9",
+		RecipientCollectionNameField: "users",
+	}
+	savedEvents := map[string]int{
+		"OnModelBeforeCreate": 2,
+		"OnModelAfterCreate":  2,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "recipient",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"id":"test"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 2,
+				"OnModelAfterCreate":  2,
+				"OnRecordViewRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(recipient),
+		},
+		{
+			Name:            "recipient list",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"totalItems":1`, `"id":"test"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":  2,
+				"OnModelAfterCreate":   2,
+				"OnRecordsListRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(recipient),
+		},
+		{
+			Name:            "not recipient",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(recipient),
+		},
+		{
+			Name:            "recipient collection",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"id":"test"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 2,
+				"OnModelAfterCreate":  2,
+				"OnRecordViewRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(map[string]any{RecipientCollectionNameField: "users"}),
+		},
+		{
+			Name:            "revoked",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(map[string]any{
+				RecipientIdField:             "vzz4enej24xtni9",
+				RecipientCollectionNameField: "users",
+				RevokedField:                 true,
+			}),
+		},
+		{
+			Name:            "expired",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(map[string]any{
+				RecipientIdField:             "vzz4enej24xtni9",
+				RecipientCollectionNameField: "users",
+				ExpiresAtField:               time.Now().Add(-time.Hour).UTC(),
+			}),
+		},
+		{
+			Name:            "public share is not a recipient share",
+			Method:          http.MethodGet,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(map[string]any{PublicField: true, TokenField: "public"}),
+		},
+		{
+			Name:            "recipient link",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/download/test/link",
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"url":"http://localhost:8090/api/pocketexport/download/test?token=`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 2,
+				"OnModelAfterCreate":  2,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(recipient),
+		},
+		{
+			Name:            "not recipient link",
+			Method:          http.MethodPost,
+			Url:             "/api/pocketexport/download/test/link",
+			RequestHeaders:  map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(recipient),
+		},
+		{
+			Name:            "recipient cannot update",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records/test",
+			Body:            strings.NewReader(`{"pinned": true}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  savedEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(recipient),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_SharedApi(t *testing.T) {
+	beforeTestFunc := func(data map[string]any) func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		return func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+			data[PublicField] = true
+			data[TokenField] = "public"
+			saveShareRecord(t, app, data)
+		}
+	}
+	errorEvents := map[string]int{
+		"OnModelBeforeCreate": 2,
+		"OnModelAfterCreate":  2,
+		"OnBeforeApiError":    1,
+		"OnAfterApiError":     1,
+	}
+	statusBeforeTestFunc := func(status string) func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		return func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+			beforeTestFunc(map[string]any{})(t, app, e)
+
+			record, err := app.Dao().FindRecordById(PocketExportCollectionName, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			record.Set(StatusField, status)
+			if err := app.Dao().SaveRecord(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	statusEvents := map[string]int{
+		"OnModelBeforeCreate": 2,
+		"OnModelAfterCreate":  2,
+		"OnModelBeforeUpdate": 1,
+		"OnModelAfterUpdate":  1,
+		"OnBeforeApiError":    1,
+		"OnAfterApiError":     1,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "invalid token",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/invalid",
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(map[string]any{}),
+		},
+		{
+			Name:            "revoked",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/public",
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(map[string]any{RevokedField: true}),
+		},
+		{
+			Name:            "expired",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/public",
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents:  errorEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc(map[string]any{ExpiresAtField: time.Now().Add(-time.Hour).UTC()}),
+		},
+		{
+			Name:            "pending export",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/public",
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"message":"The export output is not generated yet."`},
+			ExpectedEvents:  statusEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  statusBeforeTestFunc(StatusPending),
+		},
+		{
+			Name:            "failed export",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/public",
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"message":"The export generation failed."`},
+			ExpectedEvents:  statusEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  statusBeforeTestFunc(StatusFailed),
+		},
+		{
+			Name:            "valid token",
+			Method:          http.MethodGet,
+			Url:             "/api/pocketexport/shared/public",
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"nội dung,ngày tạo"},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 2,
+				"OnModelAfterCreate":  2,
+				"OnModelBeforeUpdate": 1,
+				"OnModelAfterUpdate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc(map[string]any{ExpiresAtField: time.Now().Add(time.Hour).UTC()}),
+			AfterTestFunc: func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+				record, err := app.Dao().FindRecordById(PocketExportCollectionName, "test")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if record.GetInt(DownloadCountField) != 1 {
+					t.Fatalf("expect 1 download, got %d", record.GetInt(DownloadCountField))
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_ShareUpdateApi(t *testing.T) {
+	adminToken := getAdminToken(t)
+	beforeTestFunc := func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		saveShareRecord(t, app, map[string]any{
+			"id":                         "sharetest12345",
+			RecipientIdField:             "vzz4enej24xtni9",
+			RecipientCollectionNameField: "users",
+		})
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "immutable field",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records/sharetest12345",
+			Body:            strings.NewReader(`{"recipientId": "djh54wc2hpkhfkw"}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"recipientId":{"code":"validation_read_only"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         2,
+				"OnModelAfterCreate":          2,
+				"OnRecordBeforeUpdateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "revoke",
+			Method:          http.MethodPatch,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records/sharetest12345",
+			Body:            strings.NewReader(`{"revoked": true}`),
+			RequestHeaders:  map[string]string{"Authorization": adminToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"revoked":true`, `"recipientId":"vzz4enej24xtni9"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         2,
+				"OnModelAfterCreate":          2,
+				"OnRecordBeforeUpdateRequest": 1,
+				"OnRecordAfterUpdateRequest":  1,
+				"OnModelBeforeUpdate":         1,
+				"OnModelAfterUpdate":          1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_ShareCreateApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	otherToken := getUserToken(t, "djh54wc2hpkhfkw")
+	beforeTestFunc := func(t *testing.T, app *tests.TestApp, e *echo.Echo) {
+		record := getExportRecord(t, app)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		if err := app.Dao().SaveRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createdEvents := map[string]int{
+		"OnRecordBeforeCreateRequest": 1,
+		"OnRecordAfterCreateRequest":  1,
+		"OnModelBeforeCreate":         2,
+		"OnModelAfterCreate":          2,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "not owner",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:            strings.NewReader(`{"export": "test", "recipientCollectionName": "users"}`),
+			RequestHeaders:  map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"data":{}`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate": 1,
+				"OnModelAfterCreate":  1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "invalid recipient collection",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:            strings.NewReader(`{"export": "test", "recipientCollectionName": "messages"}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"recipientCollectionName":{"code":"validation_invalid_recipient"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "invalid recipient",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:            strings.NewReader(`{"export": "test", "recipientId": "missing", "recipientCollectionName": "users"}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"recipientId":{"code":"validation_invalid_recipient"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "public share with recipient",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:            strings.NewReader(`{"export": "test", "public": true, "recipientCollectionName": "users"}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"public":{"code":"validation_public_share_recipient"`},
+			ExpectedEvents: map[string]int{
+				"OnModelBeforeCreate":         1,
+				"OnModelAfterCreate":          1,
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc: beforeTestFunc,
+		},
+		{
+			Name:            "recipient share",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:            strings.NewReader(`{"export": "test", "recipientId": "djh54wc2hpkhfkw", "recipientCollectionName": "users", "token": "guess"}`),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"recipientId":"djh54wc2hpkhfkw"`, `"token":""`},
+			ExpectedEvents:  createdEvents,
+			TestAppFactory:  newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:  beforeTestFunc,
+		},
+		{
+			Name:               "public share",
+			Method:             http.MethodPost,
+			Url:                "/api/collections/" + PocketExportShareCollectionName + "/records",
+			Body:               strings.NewReader(`{"export": "test", "public": true, "token": "guess"}`),
+			RequestHeaders:     map[string]string{"Authorization": userToken},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"public":true`},
+			NotExpectedContent: []string{`"token":"guess"`, `"token":""`},
+			ExpectedEvents:     createdEvents,
+			TestAppFactory:     newRegisteredTestApp(AutoDelete(false)),
+			BeforeTestFunc:     beforeTestFunc,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,218 @@
+package pocketexport
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+var errInvalidUnwind = validation.NewError(
+	"validation_invalid_unwind",
+	"the unwind field must be a relation, select or json field of the export collection",
+)
+
+// validateUnwind validates the unwind field of the export, a relation, select or json field
+// of the export collection that the field policies of the owner allow
+func (s *PocketExport) validateUnwind(export *Export) error {
+	name := export.GetString(UnwindField)
+	if name == "" {
+		return nil
+	}
+
+	field := export.ExportCollection().Schema.GetFieldByName(name)
+	if field == nil {
+		return errInvalidUnwind
+	}
+
+	switch field.Type {
+	case schema.FieldTypeRelation, schema.FieldTypeSelect, schema.FieldTypeJson:
+	default:
+		return errInvalidUnwind
+	}
+
+	if policy := s.config.fieldPolicy(export.ExportCollection().Name, export.AuthRecord()); policy != nil && !policy.allows(name) {
+		return errFieldNotAllowed.SetParams(map[string]any{"field": name})
+	}
+
+	return s.validateHeaderVisibility(export, name+"."+schema.FieldNameId)
+}
+
+// exportUnwindElements returns the values of the unwind field of the record, the expanded records
+// of a relation, the values of a select and the items of a json array or the json value itself
+func exportUnwindElements(record *models.Record, name string) []any {
+	field := record.Collection().Schema.GetFieldByName(name)
+	if field == nil {
+		return nil
+	}
+
+	elements := []any{}
+	switch field.Type {
+	case schema.FieldTypeRelation:
+		switch v := record.Expand()[name].(type) {
+		case *models.Record:
+			elements = append(elements, v)
+		case []*models.Record:
+			for _, r := range v {
+				elements = append(elements, r)
+			}
+		}
+	case schema.FieldTypeSelect:
+		for _, v := range record.GetStringSlice(name) {
+			elements = append(elements, v)
+		}
+	case schema.FieldTypeJson:
+		var value any
+		if err := record.UnmarshalJSONField(name, &value); err != nil || value == nil {
+			return elements
+		}
+
+		if items, ok := value.([]any); ok {
+			return items
+		}
+		elements = append(elements, value)
+	}
+
+	return elements
+}
+
+// countExportUnwoundRows counts the rows of the unwound export, the values of the unwind field
+// of the matching records and one row per record without values when they are kept.
+// The relations count the related records the owner can view like the unwound expands,
+// the deleted related records and the ones hidden by the view rule are not counted
+func (s *PocketExport) countExportUnwoundRows(export *Export) (int, error) {
+	dao := s.app.Dao()
+	collection := export.ExportCollection()
+	name := export.GetString(UnwindField)
+
+	filters, err := s.generateExportFilters(export.GetString(FilterField), export)
+	if err != nil {
+		return 0, err
+	}
+
+	fieldResolver := s.exportFieldResolver(export)
+	query := dao.RecordQuery(collection)
+	for _, f := range filters {
+		expr, err := f.BuildExpr(fieldResolver)
+		if err != nil {
+			return 0, err
+		}
+		if expr != nil {
+			query.AndWhere(expr)
+		}
+	}
+
+	if err := fieldResolver.UpdateQuery(query); err != nil {
+		return 0, err
+	}
+
+	// the joins of the filter can repeat the records
+	rows := query.
+		Distinct(true).
+		Select("[["+collection.Name+".id]]", "[["+collection.Name+"."+name+"]] AS [[unwindValue]]").
+		Build()
+	params := dbx.Params{}
+	for k, v := range rows.Params() {
+		params[k] = v
+	}
+
+	// the relations and selects are json arrays or single values, the json fields
+	// are unwound when they are arrays
+	column := "[[__unwound.unwindValue]]"
+	elements := "CASE WHEN json_valid(" + column + ") THEN CASE json_type(" + column + ")" +
+		" WHEN 'array' THEN json_array_length(" + column + ") WHEN 'null' THEN 0 ELSE 1 END" +
+		" WHEN COALESCE(" + column + ", '') = '' THEN 0 ELSE 1 END"
+
+	if field := collection.Schema.GetFieldByName(name); field != nil && field.Type == schema.FieldTypeRelation {
+		field.InitOptions()
+		options, _ := field.Options.(*schema.RelationOptions)
+		if options == nil {
+			return 0, errInvalidUnwind
+		}
+
+		relCollection, err := dao.FindCollectionByNameOrId(options.CollectionId)
+		if err != nil {
+			return 0, err
+		}
+
+		relQuery := dao.RecordQuery(relCollection).
+			Select("COUNT(DISTINCT [[" + relCollection.Name + ".id]])").
+			AndWhere(dbx.NewExp("[[" + relCollection.Name + ".id]] IN (SELECT [[value]] FROM json_each(CASE WHEN json_valid(" +
+				column + ") THEN " + column + " ELSE json_array(" + column + ") END))"))
+		if err := applyExportViewRule(dao, export, relCollection, relQuery, name); err != nil {
+			return 0, err
+		}
+
+		related := relQuery.Build()
+		for k, v := range related.Params() {
+			params[k] = v
+		}
+		elements = "(" + related.SQL() + ")"
+	}
+
+	if export.GetBool(UnwindKeepEmptyField) {
+		elements = "MAX(" + elements + ", 1)"
+	}
+
+	total := 0
+	err = dao.DB().
+		NewQuery("SELECT COALESCE(SUM(" + elements + "), 0) FROM (" + rows.SQL() + ") {{__unwound}}").
+		Bind(params).
+		Row(&total)
+
+	return total, err
+}
+
+// generateExportEachUnwoundRecord calls fn with the record once per value of the export unwind field,
+// the field and its expand hold the single value during the call and are restored after.
+// The records without values are skipped unless they are kept, then the field is empty
+func (s *PocketExport) generateExportEachUnwoundRecord(
+	record *models.Record,
+	export *Export,
+	fn func(record *models.Record) error,
+) error {
+	name := export.GetString(UnwindField)
+	if name == "" {
+		return fn(record)
+	}
+
+	elements := exportUnwindElements(record, name)
+	if len(elements) == 0 {
+		if !export.GetBool(UnwindKeepEmptyField) {
+			return nil
+		}
+		elements = append(elements, nil)
+	}
+
+	value := record.Get(name)
+	expand := record.Expand()
+	defer func() {
+		record.Set(name, value)
+		record.SetExpand(expand)
+	}()
+
+	for _, element := range elements {
+		unwoundExpand := make(map[string]any, len(expand))
+		for k, v := range expand {
+			unwoundExpand[k] = v
+		}
+
+		switch v := element.(type) {
+		case *models.Record:
+			record.Set(name, v.Id)
+			unwoundExpand[name] = v
+		case nil:
+			record.Set(name, nil)
+			delete(unwoundExpand, name)
+		default:
+			record.Set(name, v)
+		}
+		record.SetExpand(unwoundExpand)
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
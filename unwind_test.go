@@ -0,0 +1,253 @@
+package pocketexport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/xuri/excelize/v2"
+)
+
+func Test_pocketExport_UnwindExport(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupMultiValuedMessages(t, testApp)
+
+	exportService := New(testApp)
+	headers := []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "products.name", "header": "sản phẩm"},
+		map[string]any{"fieldName": "products.price", "header": "giá"},
+		map[string]any{"fieldName": "products", "header": "số", "aggregate": "count"},
+	}
+
+	generate := func(format string, keepEmpty bool) []byte {
+		record := getExportRecord(t, testApp)
+		record.Set(FormatField, format)
+		record.Set(UnwindField, "products")
+		record.Set(UnwindKeepEmptyField, keepEmpty)
+		record.Set(HeadersField, headers)
+		export, err := exportService.ValidateAndFill(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a row per product and the kept message without products
+		rowCount := 2
+		if keepEmpty {
+			rowCount = 3
+		}
+		if record.GetInt(RowCountField) != rowCount {
+			t.Fatalf("expect %d rows, got %d", rowCount, record.GetInt(RowCountField))
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := exportService.GenerateExportOutput(buf, export); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	expect := "nội dung,sản phẩm,giá,số\n" +
+		"test1,bút,10,1\n" +
+		"test1,vở,25.5,1\n"
+	if output := string(generate(FormatCSV, false)); output != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, output)
+	}
+
+	expect += "test2,,,0\n"
+	if output := string(generate(FormatCSV, true)); output != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, output)
+	}
+
+	expect = `[{"nội dung":"test1","sản phẩm":"bút","giá":10,"số":1},` +
+		`{"nội dung":"test1","sản phẩm":"vở","giá":25.5,"số":1}]`
+	if output := string(generate(FormatJSON, false)); output != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, output)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(generate(FormatXLSX, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 4 || strings.Join(rows[2], ",") != "test1,vở,25.5,1" || rows[3][0] != "test2" {
+		t.Fatalf("unexpected xlsx rows %v", rows)
+	}
+
+	// the parent record is restored
+	record := getExportRecord(t, testApp)
+	record.Set(UnwindField, "labels")
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "labels", "header": "nhãn"},
+		map[string]any{"fieldName": "products.name", "header": "sản phẩm"},
+	})
+	export, err := exportService.ValidateAndFill(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := exportService.GenerateExportPreview(export, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(preview.Rows) != 1 || preview.Rows[0][0] != "a" || preview.Rows[0][1] != "bút, vở" {
+		t.Fatalf("unexpected preview rows %v", preview.Rows)
+	}
+	if preview.TotalItems != 2 {
+		t.Fatalf("expect 2 total items, got %d", preview.TotalItems)
+	}
+
+	// json arrays
+	messages, err := testApp.Dao().FindCollectionByNameOrId("messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages.Schema.AddField(&schema.SchemaField{Name: "lines", Type: schema.FieldTypeJson, Options: &schema.JsonOptions{}})
+	if err := testApp.Dao().SaveCollection(messages); err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := testApp.Dao().FindFirstRecordByData("messages", "message", "test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Set("lines", []any{1, "hai"})
+	if err := testApp.Dao().SaveRecord(message); err != nil {
+		t.Fatal(err)
+	}
+
+	record = getExportRecord(t, testApp)
+	record.Set(UnwindField, "lines")
+	record.Set(HeadersField, []any{
+		map[string]any{"fieldName": "message", "header": "nội dung"},
+		map[string]any{"fieldName": "lines", "header": "dòng"},
+	})
+	if export, err = exportService.ValidateAndFill(record); err != nil {
+		t.Fatal(err)
+	}
+	if record.GetInt(RowCountField) != 2 {
+		t.Fatalf("expect 2 rows, got %d", record.GetInt(RowCountField))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := exportService.GenerateExportOutput(buf, export); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = "nội dung,dòng\n" +
+		"test2,1\n" +
+		"test2,\"\"\"hai\"\"\"\n"
+	if buf.String() != expect {
+		t.Fatalf("expect:\n%v\ngot:\n%v", expect, buf.String())
+	}
+
+	// the max rows apply to the unwound rows
+	MaxRows(2)(&exportService.config)
+	record = getExportRecord(t, testApp)
+	record.Set(UnwindField, "lines")
+	record.Set(UnwindKeepEmptyField, true)
+	if _, err := exportService.ValidateAndFill(record); err == nil {
+		t.Fatal("should exceed the max rows")
+	} else if e, ok := err.(validation.Errors)[FilterField].(validation.Error); !ok || e.Code() != "validation_too_many_rows" {
+		t.Fatalf("expect too many rows, got %v", err)
+	}
+	MaxRows(0)(&exportService.config)
+
+	scenarios := []struct {
+		unwind string
+		code   string
+	}{
+		{"message", "validation_invalid_unwind"},
+		{"unknown", "validation_invalid_unwind"},
+		{"author", "validation_field_not_allowed"},
+	}
+
+	CollectionFieldPolicy("messages", "users", FieldPolicy{Deny: []string{"author"}})(&exportService.config)
+	for i, s := range scenarios {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, "vzz4enej24xtni9")
+		record.Set(OwnerCollectionNameField, "users")
+		record.Set(UnwindField, s.unwind)
+		if _, err := exportService.ValidateAndFill(record); err == nil {
+			t.Fatalf("(%d) should have error", i)
+		} else if e, ok := err.(validation.Errors)[UnwindField].(validation.Error); !ok || e.Code() != s.code {
+			t.Fatalf("(%d) expect %s, got %v", i, s.code, err)
+		}
+	}
+}
+
+func Test_pocketExport_UnwindRelationRowCount(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+	setupMultiValuedMessages(t, testApp)
+
+	// a deleted product and a product hidden from the auth records
+	products, err := testApp.Dao().FindCollectionByNameOrId("products")
+	if err != nil {
+		t.Fatal(err)
+	}
+	products.ViewRule = types.Pointer("@request.auth.id = '' || name != 'vở'")
+	if err := testApp.Dao().SaveCollection(products); err != nil {
+		t.Fatal(err)
+	}
+
+	test1, err := testApp.Dao().FindFirstRecordByData("messages", "message", "test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test1.Set("products", append(test1.GetStringSlice("products"), "deleted"))
+	if err := testApp.Dao().SaveRecord(test1); err != nil {
+		t.Fatal(err)
+	}
+
+	exportService := New(testApp)
+	for _, s := range []struct {
+		ownerId             string
+		ownerCollectionName string
+		expect              string
+	}{
+		{"x9fs8mten7zmwcv", "", "sản phẩm\nbút\nvở\n"},
+		{"vzz4enej24xtni9", "users", "sản phẩm\nbút\n"},
+	} {
+		record := getExportRecord(t, testApp)
+		record.Set(OwnerIdField, s.ownerId)
+		record.Set(OwnerCollectionNameField, s.ownerCollectionName)
+		record.Set(UnwindField, "products")
+		record.Set(HeadersField, []any{map[string]any{"fieldName": "products.name", "header": "sản phẩm"}})
+		export, err := exportService.ValidateAndFill(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := exportService.GenerateExportOutput(buf, export); err != nil {
+			t.Fatal(err)
+		}
+
+		// the row count matches the written rows
+		if buf.String() != s.expect {
+			t.Fatalf("%s: expect:\n%v\ngot:\n%v", s.ownerId, s.expect, buf.String())
+		} else if rowCount := strings.Count(s.expect, "\n") - 1; record.GetInt(RowCountField) != rowCount {
+			t.Fatalf("%s: expect %d rows, got %d", s.ownerId, rowCount, record.GetInt(RowCountField))
+		}
+	}
+}
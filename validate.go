@@ -6,16 +6,61 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
 	"github.com/pocketbase/pocketbase/resolvers"
-	"github.com/pocketbase/pocketbase/tools/search"
+	"github.com/pocketbase/pocketbase/tools/list"
 )
 
 var (
-	errInvalidHeaders = validation.NewError("validation_invalid_headers", "invalid headers")
+	errInvalidHeaders  = validation.NewError("validation_invalid_headers", "invalid headers")
+	errFieldNotVisible = validation.NewError(
+		"validation_field_not_visible",
+		"the owner can never view the field {{.field}}",
+	)
+	errTooManyRows = validation.NewError(
+		"validation_too_many_rows",
+		"the export matches {{.count}} rows, the maximum is {{.max}}",
+	)
 
 	ErrIsNotExport = validation.NewError("validation_is_not_export", "is not export")
 )
 
+// exportHiddenAuthFields are the auth record fields never returned by the records api
+var exportHiddenAuthFields = []string{
+	schema.FieldNameTokenKey,
+	schema.FieldNamePasswordHash,
+	schema.FieldNameLastResetSentAt,
+	schema.FieldNameLastVerificationSentAt,
+}
+
+// validateHeaderVisibility checks that the owner could view the header field with the records api,
+// the related collections must not be admin only and the hidden auth fields are never visible.
+// The email of an auth record is exported only if it is visible to the owner.
+func (s *PocketExport) validateHeaderVisibility(export *Export, fieldName string) error {
+	if export.Admin() != nil {
+		return nil
+	}
+
+	collections, name, err := exportFieldCollections(s.app.Dao(), export.ExportCollection(), fieldName)
+	if err != nil {
+		// the invalid field paths are reported by the field resolver
+		return nil
+	}
+
+	errNotVisible := errFieldNotVisible.SetParams(map[string]any{"field": fieldName})
+	for _, collection := range collections[1:] {
+		if collection.ViewRule == nil {
+			return errNotVisible
+		}
+	}
+
+	if collections[len(collections)-1].IsAuth() && list.ExistInSlice(name, exportHiddenAuthFields) {
+		return errNotVisible
+	}
+
+	return nil
+}
+
 // validateAndFill validates the record and fills the export
 func (s *PocketExport) validateAndFill(r *models.Record) (*Export, error) {
 	if r.TableName() != PocketExportCollectionName {
@@ -34,6 +79,31 @@ func (s *PocketExport) validateAndFill(r *models.Record) (*Export, error) {
 		}
 	}
 
+	// validate the default timezone and locale of the headers
+	if timezone := r.GetString(DefaultTimezoneField); timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, validation.Errors{DefaultTimezoneField: err}
+		}
+	}
+
+	if locale := r.GetString(LocaleField); locale != "" {
+		if _, err := parseLocale(locale); err != nil {
+			return nil, validation.Errors{LocaleField: err}
+		}
+	}
+
+	if err := s.config.validateCollectionConfig(export); err != nil {
+		return nil, err
+	}
+
+	if err := s.config.validateWebhookURL(r.GetString(WebhookUrlField)); err != nil {
+		return nil, validation.Errors{WebhookUrlField: err}
+	}
+
+	if err := s.config.validateDestination(r); err != nil {
+		return nil, err
+	}
+
 	filter := r.GetString(FilterField)
 	sort := r.GetString(SortField)
 
@@ -51,57 +121,130 @@ func (s *PocketExport) validateAndFill(r *models.Record) (*Export, error) {
 		false,
 	)
 
-	// validate filter and sort
-	searchProvider := search.NewProvider(fieldResolver).
-		Query(dao.RecordQuery(export.ExportCollection())).
-		Page(1).
-		PerPage(1)
+	// only admins can export the collections without export rule
+	if export.Admin() == nil {
+		rule, err := s.collectionExportRule(export.ExportCollection())
+		if err != nil {
+			return nil, err
+		}
 
-	if filter != "" {
-		searchProvider.AddFilter(search.FilterData(filter))
+		if rule == nil {
+			return nil, validation.Errors{ExportCollectionNameField: errAdminOnlyCollection}
+		}
 	}
 
-	if sort != "" {
-		for _, sortField := range search.ParseSortFromString(sort) {
-			searchProvider.AddSort(sortField)
-		}
+	// the filter and sort cannot reveal the denied or masked fields
+	if err := s.validateFilterAndSortPolicy(export, fieldResolver); err != nil {
+		return nil, err
 	}
 
-	// ensure that the user has access to the collection
-	if export.Admin() == nil && export.ExportCollection().ListRule != nil {
-		searchProvider.AddFilter(search.FilterData(*export.ExportCollection().ListRule))
+	if err := s.validateUnwind(export); err != nil {
+		return nil, validation.Errors{UnwindField: err}
 	}
 
-	if _, err := searchProvider.Exec(&[]*models.Record{}); err != nil {
+	// validate filter and sort and count the matching rows
+	searchProvider, err := s.generateExportSearchProvider(filter, sort, export)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := searchProvider.
+		Page(1).
+		PerPage(1).
+		Exec(&[]*models.Record{})
+	if err != nil {
 		return nil, validation.Errors{
 			FilterField: err,
 			SortField:   err,
 		}
 	}
 
+	// the unwound exports have a row per value of the unwind field
+	rowCount := result.TotalItems
+	if r.GetString(UnwindField) != "" {
+		if rowCount, err = s.countExportUnwoundRows(export); err != nil {
+			return nil, validation.Errors{UnwindField: err}
+		}
+	}
+
+	r.Set(RowCountField, rowCount)
+	if maxRows := s.config.exportMaxRows(export); maxRows > 0 && rowCount > maxRows {
+		return nil, validation.Errors{
+			FilterField: errTooManyRows.SetParams(map[string]any{
+				"count": rowCount,
+				"max":   maxRows,
+			}),
+		}
+	}
+
 	// validate headers
 	headers := export.Headers()
 	for i := range headers {
 		item := &headers[i]
-		result, err := fieldResolver.Resolve(item.FieldName)
+		fieldNames, err := item.FieldNames()
 		if err != nil {
 			return nil, validation.Errors{HeadersField: err}
 		}
 
-		// we don't want to allow subquery in header
-		if result.MultiMatchSubQuery != nil {
-			return nil, validation.Errors{HeadersField: errInvalidHeaders}
+		if _, err := item.ParsedTemplate(); err != nil {
+			return nil, validation.Errors{HeadersField: err}
+		}
+
+		for _, fieldName := range fieldNames {
+			if err := s.validateHeaderVisibility(export, fieldName); err != nil {
+				return nil, validation.Errors{HeadersField: err}
+			}
+
+			// the back-relations are expanded per page, the expressions need single values
+			if isBackRelationPath(export.ExportCollection(), fieldName) {
+				if item.Expression != "" {
+					return nil, validation.Errors{HeadersField: errInvalidHeaders}
+				}
+
+				if err := s.validateBackRelationField(export, fieldName); err != nil {
+					return nil, validation.Errors{HeadersField: err}
+				}
+				continue
+			}
+
+			// the json paths are extracted from the json field
+			if jsonPath, err := exportJSONPath(dao, export.ExportCollection(), fieldName); err != nil {
+				return nil, validation.Errors{HeadersField: err}
+			} else if jsonPath != nil {
+				continue
+			}
+
+			result, err := fieldResolver.Resolve(fieldName)
+			if err != nil {
+				return nil, validation.Errors{HeadersField: err}
+			}
+
+			// the multi-valued fields are aggregated, the expressions need single values
+			if result.MultiMatchSubQuery != nil && item.Expression != "" {
+				return nil, validation.Errors{HeadersField: errInvalidHeaders}
+			}
 		}
 
-		// validate timezone
-		if item.Timezone == "" {
-			continue
+		if err := s.validateAggregate(export, item); err != nil {
+			return nil, validation.Errors{HeadersField: err}
 		}
 
-		if _, err := time.LoadLocation(item.Timezone); err != nil {
+		// validate timezone, locale and currency
+		if err := item.validateFormat(); err != nil {
 			return nil, validation.Errors{HeadersField: err}
 		}
 	}
 
+	// validate the field policies of the owner
+	if _, err := s.exportHeaderMasks(export); err != nil {
+		return nil, validation.Errors{HeadersField: err}
+	}
+
+	fingerprint, err := exportFingerprint(export)
+	if err != nil {
+		return nil, err
+	}
+	r.Set(FingerprintField, fingerprint)
+
 	return export, nil
 }
@@ -0,0 +1,307 @@
+package pocketexport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/routine"
+)
+
+const (
+	// WebhookSignatureHeader is the header of the hex encoded HMAC-SHA256
+	// of the webhook timestamp and payload joined by a dot
+	WebhookSignatureHeader = "X-PocketExport-Signature"
+	// WebhookTimestampHeader is the header of the webhook unix timestamp
+	WebhookTimestampHeader = "X-PocketExport-Timestamp"
+)
+
+// webhookTimeout is the timeout of a single webhook request
+const webhookTimeout = 10 * time.Second
+
+var errWebhookNotAllowed = validation.NewError("validation_webhook_not_allowed", "webhook host is not allowed")
+
+// WebhookPayload is the json body posted to the webhooks
+type WebhookPayload struct {
+	Id                   string `json:"id"`
+	ExportCollectionName string `json:"exportCollectionName"`
+	Status               string `json:"status"`
+	Error                string `json:"error"`
+	RowCount             int    `json:"rowCount"`
+	// FileKey is the filesystem key of the output, empty if the export failed
+	FileKey string `json:"fileKey"`
+	// Checksum is the hex encoded sha256 of the output, empty if the export failed
+	Checksum string `json:"checksum"`
+	// Destination is the name of the export destination
+	Destination string `json:"destination"`
+	// DestinationKey is the path of the output in the export destination
+	DestinationKey string `json:"destinationKey"`
+}
+
+// validateWebhookURL validates the webhook url of an export against the allowlist
+func (rc *registerConfig) validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errWebhookNotAllowed
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range rc.webhookAllowlist {
+		allowed = strings.ToLower(allowed)
+		if allowed == host || allowed == strings.ToLower(u.Host) {
+			return nil
+		}
+
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+
+	return errWebhookNotAllowed
+}
+
+// bindWebhooks resumes the pending webhook retries when the app starts
+func (p *PocketExport) bindWebhooks() {
+	p.app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := p.resumeExportWebhooks(); err != nil {
+			log.Printf("pocketexport: resume webhooks failed: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// exportWebhookURLs returns the global webhooks and the webhook url of the export
+func (p *PocketExport) exportWebhookURLs(record *models.Record) []string {
+	urls := append([]string{}, p.config.webhooks...)
+	if u := record.GetString(WebhookUrlField); u != "" {
+		urls = append(urls, u)
+	}
+
+	return urls
+}
+
+// fireExportWebhooks delivers the webhooks of the export in background
+func (p *PocketExport) fireExportWebhooks(record *models.Record) {
+	if len(p.exportWebhookURLs(record)) == 0 {
+		return
+	}
+
+	recordId := record.Id
+	routine.FireAndForget(func() {
+		if err := p.deliverExportWebhooks(recordId); err != nil {
+			log.Printf("pocketexport: webhook failed: %v", err)
+		}
+	})
+}
+
+// newWebhookPayload returns the webhook payload of the export record
+func (p *PocketExport) newWebhookPayload(record *models.Record) (*WebhookPayload, error) {
+	payload := &WebhookPayload{
+		Id:                   record.Id,
+		ExportCollectionName: record.GetString(ExportCollectionNameField),
+		Status:               record.GetString(StatusField),
+		Error:                record.GetString(ErrorField),
+		RowCount:             record.GetInt(RowCountField),
+		Destination:          record.GetString(DestinationField),
+		DestinationKey:       record.GetString(DestinationKeyField),
+	}
+
+	if payload.Status == StatusFailed || record.GetString(OutputField) == "" {
+		return payload, nil
+	}
+
+	payload.FileKey = record.BaseFilesPath() + "/" + record.GetString(OutputField)
+	payload.Checksum = record.GetString(ChecksumField)
+
+	return payload, nil
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of the timestamp and body
+func webhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookClient creates the http client of the webhooks, the redirects are not followed
+// since only the host of the webhook url is checked against the allowlist
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// postWebhook posts the signed body to the url
+func (p *PocketExport) postWebhook(client *http.Client, rawURL string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if p.config.webhookSecret != "" {
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(p.config.webhookSecret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with status %d", rawURL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// deliverExportWebhooks posts the export payload to its webhooks,
+// the failed webhooks are retried with an exponential backoff
+// and the attempts are recorded on the export record.
+func (p *PocketExport) deliverExportWebhooks(recordId string) error {
+	return p.deliverExportWebhooksFrom(recordId, 0)
+}
+
+// deliverExportWebhooksFrom delivers the webhooks of the export
+// continuing after the given number of attempts.
+func (p *PocketExport) deliverExportWebhooksFrom(recordId string, attempts int) error {
+	record, err := p.app.Dao().FindRecordById(PocketExportCollectionName, recordId)
+	if err != nil {
+		return fmt.Errorf("find record failed: %w", err)
+	}
+
+	pending := p.exportWebhookURLs(record)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	payload, err := p.newWebhookPayload(record)
+	if err != nil {
+		return fmt.Errorf("create payload failed: %w", err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := newWebhookClient()
+	backoff := p.config.webhookBackoff
+	for i := 0; i < attempts; i++ {
+		backoff *= 2
+	}
+
+	for attempt := attempts + 1; ; attempt++ {
+		failed := []string{}
+		errs := []string{}
+		for _, u := range pending {
+			if err := p.postWebhook(client, u, body); err != nil {
+				failed = append(failed, u)
+				errs = append(errs, err.Error())
+			}
+		}
+		pending = failed
+
+		nextRetryAt := time.Time{}
+		if len(pending) > 0 && attempt < p.config.webhookMaxAttempts {
+			nextRetryAt = time.Now().Add(backoff).UTC()
+		}
+
+		if err := p.saveExportWebhookAttempt(recordId, attempt, errs, nextRetryAt); err != nil {
+			return fmt.Errorf("save webhook attempt failed: %w", err)
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if attempt >= p.config.webhookMaxAttempts {
+			return fmt.Errorf("export %s: %s", recordId, strings.Join(errs, "; "))
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// saveExportWebhookAttempt records a webhook attempt on a fresh copy of the export,
+// so the changes made while delivering are not overwritten.
+func (p *PocketExport) saveExportWebhookAttempt(recordId string, attempt int, errs []string, nextRetryAt time.Time) error {
+	dao := p.app.Dao()
+	record, err := dao.FindRecordById(PocketExportCollectionName, recordId)
+	if err != nil {
+		return err
+	}
+
+	record.Set(WebhookAttemptsField, attempt)
+	record.Set(WebhookErrorField, strings.Join(errs, "; "))
+	record.Set(WebhookNextRetryAtField, "")
+	if len(errs) == 0 {
+		record.Set(WebhookDeliveredAtField, time.Now().UTC())
+	} else if !nextRetryAt.IsZero() {
+		record.Set(WebhookNextRetryAtField, nextRetryAt)
+	}
+
+	return dao.SaveRecord(record)
+}
+
+// resumeExportWebhooks resumes in background the webhook retries
+// that were pending when the app stopped.
+func (p *PocketExport) resumeExportWebhooks() error {
+	records, err := p.app.Dao().FindRecordsByExpr(
+		PocketExportCollectionName,
+		dbx.NewExp("[["+WebhookNextRetryAtField+"]] != ''"),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		record := record
+		routine.FireAndForget(func() {
+			if err := p.resumeExportWebhook(record); err != nil {
+				log.Printf("pocketexport: webhook failed: %v", err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// resumeExportWebhook waits for the next retry of the export
+// and delivers its webhooks again, the failed urls are not stored
+// so all the webhooks of the export are retried.
+func (p *PocketExport) resumeExportWebhook(record *models.Record) error {
+	if wait := time.Until(record.GetDateTime(WebhookNextRetryAtField).Time()); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return p.deliverExportWebhooksFrom(record.Id, record.GetInt(WebhookAttemptsField))
+}
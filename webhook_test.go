@@ -0,0 +1,287 @@
+package pocketexport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func Test_registerConfig_ValidateWebhookURL(t *testing.T) {
+	rc := defaultRegisterConfig
+	WebhookAllowlist("hooks.example.com", "*.example.org", "127.0.0.1:8090")(&rc)
+
+	scenarios := []struct {
+		url     string
+		isValid bool
+	}{
+		{"", true},
+		{"https://hooks.example.com/export", true},
+		{"https://HOOKS.example.com:8443/export", true},
+		{"https://a.example.org/export", true},
+		{"https://example.org/export", false},
+		{"http://127.0.0.1:8090/export", true},
+		{"http://127.0.0.1:8091/export", false},
+		{"https://evil.com/export", false},
+		{"ftp://hooks.example.com/export", false},
+	}
+
+	for _, s := range scenarios {
+		err := rc.validateWebhookURL(s.url)
+		if s.isValid && err != nil {
+			t.Fatalf("%s: %v", s.url, err)
+		} else if !s.isValid && err == nil {
+			t.Fatalf("%s: should have error", s.url)
+		}
+	}
+}
+
+func Test_pocketExport_DeliverExportWebhooks(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	var mu sync.Mutex
+	calls := 0
+	var body []byte
+	var header http.Header
+	var recordId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+		if calls == 1 {
+			// the export is downloaded while the webhooks are delivered
+			record, err := testApp.Dao().FindRecordById(PocketExportCollectionName, recordId)
+			if err == nil {
+				record.Set(DownloadCountField, 7)
+				err = testApp.Dao().SaveRecord(record)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	exportService := New(testApp)
+	WebhookSecret("secret")(&exportService.config)
+	WebhookRetry(3, time.Millisecond)(&exportService.config)
+
+	record := getExportRecord(t, testApp)
+	record.Set(OutputField, exportOutputFilename(FormatCSV))
+	record.Set(StatusField, StatusSuccess)
+	record.Set(RowCountField, 2)
+	record.Set(WebhookUrlField, server.URL)
+	if err := testApp.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	recordId = record.Id
+
+	if err := exportService.uploadExportOutput(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := testApp.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	// retried after the first failure
+	if err := exportService.deliverExportWebhooks(record.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expect 2 calls, got %d", calls)
+	}
+
+	timestamp := header.Get(WebhookTimestampHeader)
+	if header.Get(WebhookSignatureHeader) != webhookSignature("secret", timestamp, body) {
+		t.Fatal("invalid signature")
+	}
+
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	record, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, record.Id)
+	fs, err := testApp.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	r, err := fs.GetFile(payload.FileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Id != record.Id || payload.Status != StatusSuccess || payload.RowCount != record.GetInt(RowCountField) {
+		t.Fatalf("wrong payload %s", body)
+	} else if payload.Checksum != hex.EncodeToString(hash.Sum(nil)) {
+		t.Fatal("wrong checksum")
+	}
+
+	if record.GetInt(WebhookAttemptsField) != 2 {
+		t.Fatalf("expect 2 attempts, got %d", record.GetInt(WebhookAttemptsField))
+	} else if record.GetString(WebhookErrorField) != "" {
+		t.Fatal(record.GetString(WebhookErrorField))
+	} else if record.GetDateTime(WebhookDeliveredAtField).IsZero() {
+		t.Fatal("should be delivered")
+	} else if record.GetInt(DownloadCountField) != 7 {
+		t.Fatal("should keep the changes made while delivering")
+	}
+
+	// gives up after the max attempts
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if err := exportService.deliverExportWebhooks(record.Id); err == nil {
+		t.Fatal("should have error")
+	}
+
+	record, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, record.Id)
+	if record.GetInt(WebhookAttemptsField) != 3 {
+		t.Fatalf("expect 3 attempts, got %d", record.GetInt(WebhookAttemptsField))
+	} else if !strings.Contains(record.GetString(WebhookErrorField), "502") {
+		t.Fatal(record.GetString(WebhookErrorField))
+	} else if !record.GetDateTime(WebhookNextRetryAtField).IsZero() {
+		t.Fatal("should not retry")
+	}
+}
+
+func Test_pocketExport_WebhookUrlApi(t *testing.T) {
+	userToken := getUserToken(t, "vzz4enej24xtni9")
+	body := `{
+		"exportCollectionName": "messages",
+		"headers": [{"fieldName": "message", "header": "nội dung"}],
+		"format": "csv",
+		"ownerId": "vzz4enej24xtni9",
+		"ownerCollectionName": "users",
+		"webhookUrl": "https://evil.com/export"
+	}`
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "not allowed webhook",
+			Method:          http.MethodPost,
+			Url:             "/api/collections/" + PocketExportCollectionName + "/records",
+			Body:            strings.NewReader(body),
+			RequestHeaders:  map[string]string{"Authorization": userToken},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"webhookUrl":{"code":"validation_webhook_not_allowed"`},
+			ExpectedEvents: map[string]int{
+				"OnRecordBeforeCreateRequest": 1,
+			},
+			TestAppFactory: newRegisteredTestApp(WebhookAllowlist("hooks.example.com")),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func Test_pocketExport_WebhookRedirect(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	internalCalls := 0
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalCalls++
+	}))
+	defer internal.Close()
+
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer allowed.Close()
+
+	exportService := New(testApp)
+	WebhookRetry(1, time.Millisecond)(&exportService.config)
+
+	record := getExportRecord(t, testApp)
+	record.Set(StatusField, StatusFailed)
+	record.Set(WebhookUrlField, allowed.URL)
+	if err := testApp.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	// the redirect is not followed
+	if err := exportService.deliverExportWebhooks(record.Id); err == nil || !strings.Contains(err.Error(), "307") {
+		t.Fatalf("expect redirect status error, got %v", err)
+	} else if internalCalls != 0 {
+		t.Fatal("should not follow the redirect")
+	}
+}
+
+func Test_pocketExport_ResumeExportWebhook(t *testing.T) {
+	testApp, err := tests.NewTestApp("./test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testApp.Cleanup()
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	exportService := New(testApp)
+	WebhookRetry(3, time.Millisecond)(&exportService.config)
+
+	// the app stopped after the first failed attempt
+	record := getExportRecord(t, testApp)
+	record.Set(StatusField, StatusFailed)
+	record.Set(WebhookUrlField, server.URL)
+	record.Set(WebhookAttemptsField, 1)
+	record.Set(WebhookErrorField, "connection refused")
+	record.Set(WebhookNextRetryAtField, time.Now().Add(-time.Second).UTC())
+	if err := testApp.Dao().SaveRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportService.resumeExportWebhook(record); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expect 1 call, got %d", calls)
+	}
+
+	record, _ = testApp.Dao().FindRecordById(PocketExportCollectionName, record.Id)
+	if record.GetInt(WebhookAttemptsField) != 2 {
+		t.Fatalf("expect 2 attempts, got %d", record.GetInt(WebhookAttemptsField))
+	} else if record.GetString(WebhookErrorField) != "" {
+		t.Fatal(record.GetString(WebhookErrorField))
+	} else if !record.GetDateTime(WebhookNextRetryAtField).IsZero() {
+		t.Fatal("should not retry")
+	} else if record.GetDateTime(WebhookDeliveredAtField).IsZero() {
+		t.Fatal("should be delivered")
+	}
+}